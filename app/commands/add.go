@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type AddCommand struct{}
+
+func (c *AddCommand) GetName() string {
+	return "add"
+}
+
+func (c *AddCommand) Execute(cmd *Command) error {
+	// Format: add [-v] [-f] [--] <pathspec>...
+	verbose, force := false, false
+	var pathspecs []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			pathspecs = append(pathspecs, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "-f" || arg == "--force":
+			force = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			pathspecs = append(pathspecs, arg)
+		}
+	}
+
+	if len(pathspecs) == 0 {
+		return fmt.Errorf("nothing specified, nothing added")
+	}
+
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Ignore rules only keep untracked files out; -f adds them anyway
+	var ignore *IgnoreMatcher
+	if !force {
+		if ignore, err = NewIgnoreMatcher(); err != nil {
+			return err
+		}
+	}
+
+	var ignored []string
+	for _, arg := range pathspecs {
+		pathspec, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+		ignoredPath, err := addPath(index, ignore, pathspec, verbose)
+		if err != nil {
+			return err
+		}
+		if ignoredPath {
+			ignored = append(ignored, arg)
+		}
+	}
+
+	if err := index.Write(indexPath); err != nil {
+		return err
+	}
+	if len(ignored) > 0 {
+		return fmt.Errorf("the following paths are ignored by one of your .gitignore files:\n%s\nUse -f if you really want to add them", strings.Join(ignored, "\n"))
+	}
+	return nil
+}
+
+// addPath stages the file or every file below the directory at pathspec,
+// and unstages index entries under it whose files are gone. Untracked
+// files the ignore rules match are skipped; ignored reports that the
+// pathspec itself named an ignored path
+func addPath(index *Index, ignore *IgnoreMatcher, pathspec string, verbose bool) (ignored bool, err error) {
+	root := pathspec
+	if root == "" {
+		root = "."
+	}
+
+	found := make(map[string]bool)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(path)
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if name == "." {
+				return nil
+			}
+			if ignore != nil && ignore.Ignored(name, true) && !index.HasDirectory(name) && !index.Has(name) {
+				ignored = ignored || name == pathspec
+				return filepath.SkipDir
+			}
+			if isNestedRepository(path) {
+				// Another repository is recorded as a gitlink to its HEAD
+				found[name] = true
+				if err := addFile(index, name, verbose); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if ignore != nil && ignore.Ignored(name, false) && !index.Has(name) {
+			ignored = ignored || name == pathspec
+			return nil
+		}
+		found[name] = true
+		return addFile(index, name, verbose)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("error adding '%s': %w", pathspec, err)
+	}
+	if ignored {
+		return true, nil
+	}
+
+	removed := false
+	for _, entry := range append([]*IndexEntry(nil), index.Entries...) {
+		if matchesPathspec(entry.Path, pathspec) && !found[entry.Path] {
+			index.Remove(entry.Path)
+			removed = true
+			if verbose {
+				fmt.Printf("remove '%s'\n", entry.Path)
+			}
+		}
+	}
+
+	if len(found) == 0 && !removed {
+		return false, fmt.Errorf("pathspec '%s' did not match any files", pathspec)
+	}
+	return false, nil
+}
+
+// addFile stages a file, symlink or nested repository, writing its blob
+func addFile(index *Index, path string, verbose bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	sha, err := hashWorktreeEntry(path, info, true)
+	if err != nil {
+		return err
+	}
+
+	if existing := index.Find(path); existing != nil && existing.SHA == sha && existing.Mode == indexMode(info) {
+		// Only refresh the stat data
+		fillStatData(existing, info)
+		existing.Size = uint32(info.Size())
+		return nil
+	}
+
+	index.Add(NewIndexEntry(path, info, sha))
+	if verbose {
+		fmt.Printf("add '%s'\n", path)
+	}
+	return nil
+}
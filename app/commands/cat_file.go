@@ -1,37 +1,146 @@
 package commands
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
 type CatFileCommand struct{}
 
-func (c *CatFileCommand) Execute(cmd *Command) {
-	if len(cmd.Args) != 2 {
-		fmt.Println(cmd.Usage)
-		os.Exit(1)
+func (c *CatFileCommand) GetName() string {
+	return "cat-file"
+}
+
+func (c *CatFileCommand) Execute(cmd *Command) error {
+	// Format: cat-file (-t | -s | -e | -p | <type>) <object>
+	//         cat-file (--batch | --batch-check) [--buffer]
+	var mode string
+	var batch, batchCheck, buffer bool
+	var positional []string
+
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "-t", "-s", "-e", "-p":
+			mode = arg
+		case "--batch":
+			batch = true
+		case "--batch-check":
+			batchCheck = true
+		case "--buffer":
+			buffer = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
 	}
 
-	// Read and decompress the git object
-	data, err := ReadGitObject(cmd.Args[1])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading git object: %s\n", err)
-		os.Exit(1)
+	if batch || batchCheck {
+		if mode != "" || len(positional) > 0 {
+			return fmt.Errorf("--batch and --batch-check take object names on stdin only")
+		}
+		return catFileBatch(os.Stdin, os.Stdout, batch, buffer)
 	}
 
-	// Parse the object to extract content
-	_, content, err := ParseGitObject(data)
+	// Without a flag the first argument is the expected type
+	if mode == "" && len(positional) == 2 {
+		mode, positional = positional[0], positional[1:]
+	}
+	if mode == "" || len(positional) != 1 {
+		return fmt.Errorf("usage: cat-file (-t | -s | -e | -p | <type>) <object>")
+	}
+
+	sha, err := ResolveRevision(positional[0])
+	if err != nil {
+		if mode == "-e" {
+			return ErrExitStatus
+		}
+		return err
+	}
+	objectType, content, err := ReadObject(sha)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing git object: %s\n", err)
-		os.Exit(1)
+		var notFound *ObjectNotFoundError
+		if mode == "-e" && errors.As(err, &notFound) {
+			return ErrExitStatus
+		}
+		return err
 	}
 
-	// Print the content to stdout
-	fmt.Printf("%s", strings.TrimSuffix(string(content), "\n"))
+	switch mode {
+	case "-t":
+		fmt.Println(objectType)
+	case "-s":
+		fmt.Println(len(content))
+	case "-e":
+	case "-p":
+		return prettyPrintObject(sha, objectType, content)
+	default:
+		if GitObjectType(mode) != objectType {
+			return fmt.Errorf("object %s is a %s, not a %s", positional[0], objectType, mode)
+		}
+		os.Stdout.Write(content)
+	}
+	return nil
 }
 
-func (c *CatFileCommand) GetName() string {
-	return "cat-file"
+// prettyPrintObject prints an object for people: trees as an ls-tree
+// listing, everything else as it is stored
+func prettyPrintObject(sha string, objectType GitObjectType, content []byte) error {
+	switch objectType {
+	case TreeObject:
+		return listTree(sha, "", &lsTreeOptions{terminator: "\n"})
+	case TagObject:
+		if _, err := ParseTag(content); err != nil {
+			return fmt.Errorf("error parsing tag object: %w", err)
+		}
+	}
+	_, err := os.Stdout.Write(content)
+	return err
+}
+
+// catFileBatch answers one object name per input line with
+// "<sha> <type> <size>", followed by the content and a newline when
+// withContent is set, or "<name> missing". Output is flushed after each
+// object unless buffer is set, so callers can interleave requests
+func catFileBatch(in io.Reader, out io.Writer, withContent, buffer bool) error {
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		name := scanner.Text()
+
+		sha, err := ResolveRevision(name)
+		var objectType GitObjectType
+		var content []byte
+		if err == nil {
+			objectType, content, err = ReadObject(sha)
+		}
+		if err != nil {
+			var notFound *ObjectNotFoundError
+			if !errors.As(err, &notFound) && isObjectSHA(name) {
+				return err
+			}
+			fmt.Fprintf(writer, "%s missing\n", name)
+		} else {
+			fmt.Fprintf(writer, "%s %s %d\n", sha, objectType, len(content))
+			if withContent {
+				writer.Write(content)
+				writer.WriteByte('\n')
+			}
+		}
+
+		if !buffer {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
 }
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type CheckIgnoreCommand struct{}
+
+func (c *CheckIgnoreCommand) GetName() string {
+	return "check-ignore"
+}
+
+func (c *CheckIgnoreCommand) Execute(cmd *Command) error {
+	// Format: check-ignore [-v] [-n] [-z] [--no-index] (--stdin | <pathname>...)
+	var verbose, nonMatching, noIndex, fromStdin bool
+	terminator := "\n"
+	var paths []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			paths = append(paths, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "-n" || arg == "--non-matching":
+			nonMatching = true
+		case arg == "-z":
+			terminator = "\x00"
+		case arg == "--no-index":
+			noIndex = true
+		case arg == "--stdin":
+			fromStdin = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if nonMatching && !verbose {
+		return fmt.Errorf("--non-matching is only valid with --verbose")
+	}
+	if fromStdin {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			paths = append(paths, scanner.Text())
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no path specified")
+	}
+
+	ignore, err := NewIgnoreMatcher()
+	if err != nil {
+		return err
+	}
+	index := NewIndex()
+	if !noIndex {
+		if index, err = ReadIndex(indexPath); err != nil {
+			return err
+		}
+	}
+
+	matched := false
+	for _, arg := range paths {
+		path, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+		isDir := strings.HasSuffix(arg, "/")
+		if info, err := os.Lstat(filepath.FromSlash(path)); err == nil && info.IsDir() {
+			isDir = true
+		}
+
+		// Tracked files are not subject to ignore rules
+		var pattern *ignorePattern
+		if path != "" && !index.Has(path) {
+			pattern = ignore.Match(path, isDir)
+		}
+
+		// Without -v only ignored paths are shown; -v also shows the
+		// negated pattern that re-included a path
+		if pattern != nil && (verbose || !pattern.negate) {
+			matched = true
+		}
+		switch {
+		case verbose && pattern != nil:
+			fmt.Printf("%s:%d:%s\t%s%s", pattern.source, pattern.line, pattern.text, arg, terminator)
+		case verbose && nonMatching:
+			fmt.Printf("::\t%s%s", arg, terminator)
+		case pattern != nil && !pattern.negate:
+			fmt.Printf("%s%s", arg, terminator)
+		}
+	}
+
+	if !matched {
+		return ErrExitStatus
+	}
+	return nil
+}
@@ -1,26 +1,53 @@
 package commands
 
 import (
-	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 type CloneCommand struct{}
 
 func (c *CloneCommand) Execute(cmd *Command) error {
-	if len(cmd.Args) < 1 {
-		fmt.Fprintf(os.Stderr, "usage: clone <repository> [<directory>]\n")
+	// Format: clone [--threads <n>] <repository> [<directory>]
+	threads := 0
+	var positional []string
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		value, isThreads := strings.CutPrefix(arg, "--threads=")
+		if arg == "--threads" {
+			if i+1 == len(cmd.Args) {
+				return fmt.Errorf("option '--threads' requires a value")
+			}
+			value, isThreads = cmd.Args[i+1], true
+			i++
+		}
+		if !isThreads {
+			positional = append(positional, arg)
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid thread count: %s", value)
+		}
+		threads = n
+	}
+
+	if len(positional) < 1 {
+		fmt.Fprintf(os.Stderr, "usage: clone [--threads <n>] <repository> [<directory>]\n")
 		os.Exit(1)
 	}
 
-	repoURL := cmd.Args[0]
+	repoURL := positional[0]
 	directory := ""
-	if len(cmd.Args) > 1 {
-		directory = cmd.Args[1]
+	if len(positional) > 1 {
+		directory = positional[1]
 	} else {
 		// Derive directory name from URL
 		parts := strings.Split(repoURL, "/")
@@ -63,19 +90,15 @@ func (c *CloneCommand) Execute(cmd *Command) error {
 	}
 
 	// Fetch the packfile containing the objects
-	packfileData, err := FetchPackfile(repoURL, headRef)
+	packStream, err := FetchPackfile(repoURL, headRef)
 	if err != nil {
 		return fmt.Errorf("error fetching packfile: %w", err)
 	}
+	defer packStream.Close()
 
-	// Validate packfile data
-	if len(packfileData) < 12 {
-		return fmt.Errorf("packfile too short: %d bytes", len(packfileData))
-	}
-
-	// Parse and store the objects from the packfile
-	if err := ParsePackfile(packfileData); err != nil {
-		return fmt.Errorf("error parsing packfile: %w", err)
+	// Store the packfile with its .idx as it arrives, the way index-pack does
+	if _, err := IndexPack(packStream, threads); err != nil {
+		return fmt.Errorf("error indexing packfile: %w", err)
 	}
 
 	// Set up the HEAD reference
@@ -96,24 +119,6 @@ func (c *CloneCommand) GetName() string {
 	return "clone"
 }
 
-// initRepository initializes the basic .git directory structure
-func initRepository() error {
-	dirs := []string{".git", ".git/objects", ".git/refs", ".git/refs/heads", ".git/refs/tags"}
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("error creating directory %s: %w", dir, err)
-		}
-	}
-
-	// Create HEAD file pointing to refs/heads/main as default
-	headFileContents := []byte("ref: refs/heads/main\n")
-	if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-		return fmt.Errorf("error writing HEAD file: %w", err)
-	}
-
-	return nil
-}
-
 // setupHeadReference sets up the HEAD reference and the default branch
 func setupHeadReference(headSHA string, references map[string]string) error {
 	// Find the branch name that corresponds to the HEAD SHA
@@ -130,15 +135,12 @@ func setupHeadReference(headSHA string, references map[string]string) error {
 		branchName = "main"
 	}
 
-	// Create the branch reference file
-	branchRefPath := filepath.Join(".git", "refs", "heads", branchName)
-	if err := os.WriteFile(branchRefPath, []byte(headSHA+"\n"), 0644); err != nil {
+	// Create the branch, then point HEAD at it
+	branchRef := "refs/heads/" + branchName
+	if err := refStore.Update(branchRef, headSHA, zeroSHA); err != nil {
 		return fmt.Errorf("error writing branch reference: %w", err)
 	}
-
-	// Update HEAD to point to the correct branch
-	headContent := fmt.Sprintf("ref: refs/heads/%s\n", branchName)
-	if err := os.WriteFile(".git/HEAD", []byte(headContent), 0644); err != nil {
+	if err := refStore.WriteSymbolic("HEAD", branchRef); err != nil {
 		return fmt.Errorf("error updating HEAD: %w", err)
 	}
 
@@ -148,17 +150,11 @@ func setupHeadReference(headSHA string, references map[string]string) error {
 // checkoutWorkingDirectory checks out files from the repository into the working directory
 func checkoutWorkingDirectory(commitSHA string) error {
 	// Read the commit object to get the tree SHA
-	commitData, err := ReadGitObject(commitSHA)
+	_, commitContent, err := ReadObject(commitSHA)
 	if err != nil {
 		return fmt.Errorf("error reading commit object: %w", err)
 	}
 
-	// Parse the commit object to strip the header
-	_, commitContent, err := ParseGitObject(commitData)
-	if err != nil {
-		return fmt.Errorf("error parsing commit object: %w", err)
-	}
-
 	// Parse the commit to get the tree SHA
 	// Commit format: "tree <tree_sha>\nparent ...\nauthor ...\ncommitter ...\n\n<message>"
 	commitLines := strings.Split(string(commitContent), "\n")
@@ -173,89 +169,84 @@ func checkoutWorkingDirectory(commitSHA string) error {
 
 	treeSHA := strings.TrimSpace(strings.TrimPrefix(treeLine, "tree "))
 
-	// Read and parse the tree object
-	return checkoutTree(treeSHA, ".")
+	// Read and parse the tree object, staging each file as it is written
+	index := NewIndex()
+	if err := checkoutTree(treeSHA, ".", index); err != nil {
+		return err
+	}
+	return index.Write(indexPath)
 }
 
-// checkoutTree recursively checks out a tree object to the filesystem
-func checkoutTree(treeSHA, basePath string) error {
-	// Read the tree object
-	treeDataRaw, err := ReadGitObject(treeSHA)
+// checkoutTree recursively checks out a tree object to the filesystem,
+// adding what it writes to the index with the stat data of the new files
+func checkoutTree(treeSHA, basePath string, index *Index) error {
+	tree, err := ReadTree(treeSHA)
 	if err != nil {
 		return fmt.Errorf("error reading tree object %s: %w", treeSHA, err)
 	}
 
-	// Parse the tree object to strip the header
-	_, treeData, err := ParseGitObject(treeDataRaw)
-	if err != nil {
-		return fmt.Errorf("error parsing tree object %s: %w", treeSHA, err)
-	}
-
-	// Parse the tree data
-	// Tree format: [<mode> <name>\0<20_byte_sha>]*
-	offset := 0
-	for offset < len(treeData) {
-		// Parse mode and name
-		nullIndex := bytes.IndexByte(treeData[offset:], 0)
-		if nullIndex == -1 {
-			break
-		}
-
-		entryData := treeData[offset : offset+nullIndex]
-		spaceIndex := bytes.IndexByte(entryData, ' ')
-		if spaceIndex == -1 {
-			offset += nullIndex + 21 // Skip this entry (mode + name + \0 + 20 byte sha)
-			continue
-		}
-
-		mode := string(entryData[:spaceIndex])
-		name := string(entryData[spaceIndex+1:])
-		sha := treeData[offset+nullIndex+1 : offset+nullIndex+21]
-		shaHex := hex.EncodeToString(sha)
-
-		fullPath := filepath.Join(basePath, name)
+	for _, entry := range tree.Entries {
+		shaHex := hex.EncodeToString(entry.SHA)
+		fullPath := filepath.Join(basePath, entry.Name)
 
 		// Determine entry type from mode
-		if mode == "40000" {
+		switch entry.Mode {
+		case "40000":
 			// Directory
 			if err := os.MkdirAll(fullPath, 0755); err != nil {
 				return fmt.Errorf("error creating directory %s: %w", fullPath, err)
 			}
 			// Recursively checkout subtree
-			if err := checkoutTree(shaHex, fullPath); err != nil {
+			if err := checkoutTree(shaHex, fullPath, index); err != nil {
 				return fmt.Errorf("error checking out subtree %s: %w", fullPath, err)
 			}
-		} else {
-			// File - read the blob and write it to disk
-			blobDataRaw, err := ReadGitObject(shaHex)
+			continue
+
+		case "160000":
+			// Gitlink - the commit lives in another repository, so only
+			// its directory is created and anything in it is left alone
+			if err := os.MkdirAll(fullPath, 0755); err != nil {
+				return fmt.Errorf("error creating directory %s: %w", fullPath, err)
+			}
+
+		case "120000":
+			// Symlink - the blob holds the link target
+			_, target, err := ReadObject(shaHex)
 			if err != nil {
 				return fmt.Errorf("error reading blob %s: %w", shaHex, err)
 			}
+			if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("error replacing %s: %w", fullPath, err)
+			}
+			if err := os.Symlink(string(target), fullPath); err != nil {
+				return fmt.Errorf("error creating symlink %s: %w", fullPath, err)
+			}
 
-			// Parse blob to get content (strip header)
-			_, blobData, err := ParseGitObject(blobDataRaw)
+		default:
+			// File - read the blob and write it to disk
+			_, blobData, err := ReadObject(shaHex)
 			if err != nil {
-				return fmt.Errorf("error parsing blob %s: %w", shaHex, err)
+				return fmt.Errorf("error reading blob %s: %w", shaHex, err)
 			}
 
 			// Write file with appropriate permissions
 			perm := os.FileMode(0644)
-			if mode == "100755" {
+			if entry.Mode == "100755" {
 				perm = 0755 // Executable
 			}
 
-			// Ensure parent directory exists
-			dir := filepath.Dir(fullPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("error creating parent directory for %s: %w", fullPath, err)
-			}
-
 			if err := os.WriteFile(fullPath, blobData, perm); err != nil {
 				return fmt.Errorf("error writing file %s: %w", fullPath, err)
 			}
 		}
 
-		offset += nullIndex + 21
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", fullPath, err)
+		}
+		var sha [20]byte
+		copy(sha[:], entry.SHA)
+		index.Add(NewIndexEntry(filepath.ToSlash(fullPath), info, sha))
 	}
 
 	return nil
@@ -1,5 +1,11 @@
 package commands
 
+import "errors"
+
+// ErrExitStatus is returned by commands that report failure through the
+// exit status alone, like cat-file -e for a missing object
+var ErrExitStatus = errors.New("exit status 1")
+
 type Command struct {
 	Args  []string
 	Usage string
@@ -3,68 +3,261 @@ package commands
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"time"
+	"slices"
+	"strings"
 )
 
-type CommitTreeCommand struct{}
-
-func (c *CommitTreeCommand) GetName() string {
-	return "commit-tree"
+// Commit is a parsed commit object
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
 }
 
-func (c *CommitTreeCommand) Execute(cmd *Command) error {
-	// Parse command line arguments
-	// Format: commit-tree <tree_sha> -p <parent_sha> -m <message>
-	// or:     commit-tree <tree_sha> -m <message>
-	if len(cmd.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "Usage: commit-tree <tree_sha> [-p <parent_sha>] -m <message>\n")
-		os.Exit(1)
-	}
-
-	var treeSHA, parentSHA, commitMsg string
-	treeSHA = cmd.Args[0]
-
-	// Parse flags
-	for i := 1; i < len(cmd.Args); i++ {
-		switch cmd.Args[i] {
-		case "-p":
-			if i+1 < len(cmd.Args) {
-				parentSHA = cmd.Args[i+1]
-				i++
+// ParseCommit parses the content of a commit object. Headers other than
+// tree, parent, author and committer (encoding, gpgsig, ...) are skipped
+func ParseCommit(content []byte) (*Commit, error) {
+	commit := &Commit{}
+
+	// Headers run until the first blank line, the rest is the message
+	headers, message, found := bytes.Cut(content, []byte("\n\n"))
+	if !found {
+		headers = bytes.TrimSuffix(content, []byte("\n"))
+	}
+	commit.Message = string(message)
+
+	for i, line := range strings.Split(string(headers), "\n") {
+		// Continuation of a multi-line header such as gpgsig
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("invalid commit header: %q", line)
+		}
+		if i == 0 && key != "tree" {
+			return nil, fmt.Errorf("invalid commit: first header is %q, not tree", key)
+		}
+
+		switch key {
+		case "tree":
+			if i != 0 || !isObjectSHA(value) {
+				return nil, fmt.Errorf("invalid commit tree: %q", line)
 			}
-		case "-m":
-			if i+1 < len(cmd.Args) {
-				commitMsg = cmd.Args[i+1]
-				i++
+			commit.Tree = value
+		case "parent":
+			if !isObjectSHA(value) {
+				return nil, fmt.Errorf("invalid commit parent: %q", value)
 			}
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author = value
+		case "committer":
+			commit.Committer = value
 		}
 	}
 
-	// Get current timestamp
-	timestamp := time.Now().Unix()
+	if commit.Tree == "" {
+		return nil, fmt.Errorf("invalid commit: missing tree")
+	}
+
+	return commit, nil
+}
 
-	// Compose commit content
-	var contentBytes bytes.Buffer
+// Serialize encodes the commit in the format it is stored in
+func (c *Commit) Serialize() []byte {
 	/*
 		Commit format:
 		tree <tree_sha>
-		parent <parent_sha>  (optional)
+		parent <parent_sha>  (zero or more)
 		author <name> <email> <timestamp> <timezone>
 		committer <name> <email> <timestamp> <timezone>
 
 		<commit message>
 	*/
-	fmt.Fprintf(&contentBytes, "tree %s\n", treeSHA)
-	if parentSHA != "" {
-		fmt.Fprintf(&contentBytes, "parent %s\n", parentSHA)
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "tree %s\n", c.Tree)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&content, "author %s\n", c.Author)
+	fmt.Fprintf(&content, "committer %s\n", c.Committer)
+	fmt.Fprintf(&content, "\n%s", c.Message)
+	return content.Bytes()
+}
+
+type CommitTreeCommand struct{}
+
+func (c *CommitTreeCommand) GetName() string {
+	return "commit-tree"
+}
+
+func (c *CommitTreeCommand) Execute(cmd *Command) error {
+	// Format: commit-tree [(-p <parent>)...] [(-m <message>)...] [(-F <file>)...]
+	//         [--author=<name <email>>] [--date=<date>] <tree>
+	// Without -m or -F the message is read from stdin
+	var treeName, authorOverride, dateOverride string
+	var parentNames []string
+	var message bytes.Buffer
+	messageGiven := false
+
+	// Each -m or -F is a paragraph of its own
+	addParagraph := func(text []byte) {
+		if message.Len() > 0 {
+			message.WriteByte('\n')
+		}
+		message.Write(text)
+		messageGiven = true
+	}
+
+	args := cmd.Args
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+
+		// Options take their value as the next argument or after "="
+		value := func() (string, error) {
+			if len(args) == 0 {
+				return "", fmt.Errorf("option '%s' requires a value", arg)
+			}
+			v := args[0]
+			args = args[1:]
+			return v, nil
+		}
+
+		var err error
+		switch {
+		case arg == "-p":
+			var parent string
+			if parent, err = value(); err == nil {
+				parentNames = append(parentNames, parent)
+			}
+		case arg == "-m":
+			var text string
+			if text, err = value(); err == nil {
+				addParagraph([]byte(text + "\n"))
+			}
+		case arg == "-F":
+			var file string
+			if file, err = value(); err == nil {
+				var text []byte
+				if text, err = readMessageFile(file); err == nil {
+					addParagraph(text)
+				}
+			}
+		case arg == "--author":
+			authorOverride, err = value()
+		case arg == "--date":
+			dateOverride, err = value()
+		case strings.HasPrefix(arg, "--author="):
+			authorOverride = strings.TrimPrefix(arg, "--author=")
+		case strings.HasPrefix(arg, "--date="):
+			dateOverride = strings.TrimPrefix(arg, "--date=")
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			err = fmt.Errorf("unknown option: %s", arg)
+		default:
+			if treeName != "" {
+				err = fmt.Errorf("usage: commit-tree [(-p <parent>)...] [(-m <message>)...] [(-F <file>)...] <tree>")
+			}
+			treeName = arg
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if treeName == "" {
+		return fmt.Errorf("usage: commit-tree [(-p <parent>)...] [(-m <message>)...] [(-F <file>)...] <tree>")
 	}
-	fmt.Fprintf(&contentBytes, "author %s <%s> %d +0000\n", "Xiaoyue Lyu", "xlyu00green@gmail.com", timestamp)
-	fmt.Fprintf(&contentBytes, "committer %s <%s> %d +0000\n", "Xiaoyue Lyu", "xlyu00green@gmail.com", timestamp)
-	fmt.Fprintf(&contentBytes, "\n%s\n", commitMsg)
 
-	// Write commit object using common function
-	sha := WriteGitObject(CommitObject, contentBytes.Bytes(), true)
+	commit := &Commit{}
+	var err error
+	if commit.Tree, err = resolveObjectOfType(treeName, TreeObject); err != nil {
+		return err
+	}
+	for _, name := range parentNames {
+		parent, err := resolveObjectOfType(name, CommitObject)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(commit.Parents, parent) {
+			fmt.Fprintf(os.Stderr, "error: duplicate parent %s ignored\n", parent)
+			continue
+		}
+		commit.Parents = append(commit.Parents, parent)
+	}
+
+	config := loadConfigOrWarn()
+	author, err := authorIdentity(config)
+	if err != nil {
+		return err
+	}
+	committer, err := committerIdentity(config)
+	if err != nil {
+		return err
+	}
+
+	// --author and --date only change the author; the committer is
+	// always whoever runs the command
+	if authorOverride != "" {
+		if author.Name, author.Email, err = parseAuthor(authorOverride); err != nil {
+			return err
+		}
+	}
+	if dateOverride != "" {
+		if author.When, err = parseIdentDate(dateOverride); err != nil {
+			return err
+		}
+	}
+	commit.Author = author.String()
+	commit.Committer = committer.String()
+
+	if !messageGiven {
+		text, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading commit message: %w", err)
+		}
+		message.Write(text)
+	}
+	commit.Message = message.String()
+
+	sha := WriteGitObject(CommitObject, commit.Serialize(), true)
 	fmt.Print(string(sha))
 	return nil
 }
+
+// readMessageFile reads a commit message from a file, or from stdin for "-"
+func readMessageFile(path string) ([]byte, error) {
+	var text []byte
+	var err error
+	if path == "-" {
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read log file '%s': %w", path, err)
+	}
+	return text, nil
+}
+
+// resolveObjectOfType resolves a revision and checks that it names an
+// existing object of the given type
+func resolveObjectOfType(name string, want GitObjectType) (string, error) {
+	sha, err := ResolveRevision(name)
+	if err != nil {
+		return "", err
+	}
+	objectType, _, err := ReadObject(sha)
+	if err != nil {
+		return "", fmt.Errorf("not a valid object name %s: %w", name, err)
+	}
+	if objectType != want {
+		return "", fmt.Errorf("%s is a %s, not a valid '%s' object", name, objectType, want)
+	}
+	return sha, nil
+}
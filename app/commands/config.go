@@ -0,0 +1,512 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config scopes, in increasing order of precedence
+const (
+	configScopeSystem  = "system"
+	configScopeGlobal  = "global"
+	configScopeLocal   = "local"
+	configScopeCommand = "command"
+)
+
+// maxConfigIncludeDepth stops include cycles
+const maxConfigIncludeDepth = 10
+
+// ConfigEntry is one value of a key, in the order it was read
+type ConfigEntry struct {
+	Key     string // section and name lowercased, subsection as written
+	Value   string
+	NoValue bool   // a bare "name" line, which means true
+	Scope   string // configScope*
+	File    string // file it was read from, "" for the environment
+}
+
+// Origin describes where the entry came from the way --show-origin does
+func (e *ConfigEntry) Origin() string {
+	if e.File == "" {
+		return "command line:"
+	}
+	return "file:" + quotePath(e.File)
+}
+
+// Config is every entry of every config file, lowest precedence first,
+// so that the last entry of a key is its value
+type Config struct {
+	Entries []*ConfigEntry
+
+	skipIncludes bool // read include.path and includeIf.*.path without following them
+}
+
+// LoadConfig reads the system, global and repository config files and
+// the GIT_CONFIG_COUNT, GIT_CONFIG_KEY_<n> and GIT_CONFIG_VALUE_<n>
+// environment variables, which take precedence in that order
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+	if err := config.loadAll(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadAll appends the entries of every scope, lowest precedence first
+func (c *Config) loadAll() error {
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		if err := c.loadFile(systemConfigFile(), configScopeSystem, 0); err != nil {
+			return err
+		}
+	}
+	for _, path := range globalConfigFiles() {
+		if err := c.loadFile(path, configScopeGlobal, 0); err != nil {
+			return err
+		}
+	}
+	if err := c.loadFile(localConfigFile, configScopeLocal, 0); err != nil {
+		return err
+	}
+	return c.loadEnvironment()
+}
+
+// LoadConfigFile reads a single config file, and what it includes when
+// includes is set
+func LoadConfigFile(path, scope string, includes bool) (*Config, error) {
+	config := &Config{skipIncludes: !includes}
+	if err := config.loadFile(path, scope, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// localConfigFile is the configuration of the repository in the working directory
+var localConfigFile = filepath.Join(".git", "config")
+
+// systemConfigFile returns the machine-wide config file
+func systemConfigFile() string {
+	if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+		return path
+	}
+	return "/etc/gitconfig"
+}
+
+// globalConfigFiles returns the user's config files: the XDG one, then
+// ~/.gitconfig, unless GIT_CONFIG_GLOBAL names another
+func globalConfigFiles() []string {
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		return []string{path}
+	}
+
+	var files []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "config"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+	return files
+}
+
+// globalConfigWriteFile returns the file "config --global" changes:
+// ~/.gitconfig, unless only the XDG file exists
+func globalConfigWriteFile() (string, error) {
+	files := globalConfigFiles()
+	if len(files) == 0 {
+		return "", fmt.Errorf("$HOME not set")
+	}
+	home := files[len(files)-1]
+	if _, err := os.Stat(home); err != nil && len(files) > 1 {
+		if _, err := os.Stat(files[0]); err == nil {
+			return files[0], nil
+		}
+	}
+	return home, nil
+}
+
+// loadFile appends the entries of a config file, following include.path
+// and includeIf.<condition>.path as they appear. Missing files are skipped
+func (c *Config) loadFile(path, scope string, depth int) error {
+	if depth > maxConfigIncludeDepth {
+		return fmt.Errorf("exceeded maximum include depth (%d) while including %s", maxConfigIncludeDepth, path)
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, variable := range file.variables {
+		entry := &ConfigEntry{
+			Key:     variable.key(),
+			Value:   variable.value,
+			NoValue: variable.noValue,
+			Scope:   scope,
+			File:    path,
+		}
+		c.Entries = append(c.Entries, entry)
+
+		if c.skipIncludes || variable.name != "path" || variable.noValue {
+			continue
+		}
+		section := variable.section
+		include := section.name == "include" && section.subsection == ""
+		if section.name == "includeif" && section.subsection != "" {
+			include = includeConditionHolds(section.subsection, path)
+		}
+		if include {
+			if err := c.loadFile(includePath(variable.value, path), scope, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includePath resolves an include path: "~/" is the home directory and
+// relative paths are relative to the directory of the including file
+func includePath(value, includingFile string) string {
+	value = expandHome(value)
+	if filepath.IsAbs(value) {
+		return value
+	}
+	return filepath.Dir(includingFile) + string(filepath.Separator) + value
+}
+
+// includeConditionHolds evaluates the condition of an includeIf section:
+// "gitdir:<pattern>", "gitdir/i:<pattern>" or "onbranch:<pattern>"
+func includeConditionHolds(condition, includingFile string) bool {
+	kind, pattern, _ := strings.Cut(condition, ":")
+	switch kind {
+	case "gitdir", "gitdir/i":
+		gitDir, err := filepath.Abs(".git")
+		if err != nil {
+			return false
+		}
+		if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+			return false
+		}
+
+		// The pattern is joined by hand, as a trailing "/" matters
+		if rest, found := strings.CutPrefix(pattern, "./"); found {
+			if dir, err := filepath.Abs(filepath.Dir(includingFile)); err == nil {
+				pattern = filepath.ToSlash(dir) + "/" + rest
+			}
+		} else if rest, found := strings.CutPrefix(pattern, "~/"); found {
+			if home, err := os.UserHomeDir(); err == nil {
+				pattern = filepath.ToSlash(home) + "/" + rest
+			}
+		}
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+
+		gitDir = filepath.ToSlash(gitDir)
+		if kind == "gitdir/i" {
+			pattern, gitDir = strings.ToLower(pattern), strings.ToLower(gitDir)
+		}
+		return wildmatch(pattern, gitDir)
+
+	case "onbranch":
+		target, err := refStore.ReadSymbolic("HEAD")
+		branch, onBranch := strings.CutPrefix(target, "refs/heads/")
+		if err != nil || !onBranch {
+			return false
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return wildmatch(pattern, branch)
+	}
+	return false
+}
+
+// loadEnvironment appends the GIT_CONFIG_KEY_<n>=GIT_CONFIG_VALUE_<n>
+// pairs, n from 0 to GIT_CONFIG_COUNT-1
+func (c *Config) loadEnvironment() error {
+	countText := os.Getenv("GIT_CONFIG_COUNT")
+	if countText == "" {
+		return nil
+	}
+	count, err := strconv.Atoi(countText)
+	if err != nil || count < 0 {
+		return fmt.Errorf("bogus count in GIT_CONFIG_COUNT")
+	}
+
+	for i := 0; i < count; i++ {
+		key, set := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+		if !set {
+			return fmt.Errorf("missing config key GIT_CONFIG_KEY_%d", i)
+		}
+		value, set := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+		if !set {
+			return fmt.Errorf("missing config value GIT_CONFIG_VALUE_%d", i)
+		}
+
+		section, subsection, name, err := splitConfigKey(key)
+		if err != nil {
+			return err
+		}
+		c.Entries = append(c.Entries, &ConfigEntry{
+			Key:   canonicalConfigKey(section, subsection, name),
+			Value: value,
+			Scope: configScopeCommand,
+		})
+	}
+	return nil
+}
+
+// GetAll returns the entries of a key, lowest precedence first
+func (c *Config) GetAll(key string) []*ConfigEntry {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return nil
+	}
+	canonical := canonicalConfigKey(section, subsection, name)
+
+	var entries []*ConfigEntry
+	for _, entry := range c.Entries {
+		if entry.Key == canonical {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Get returns the value of a key: its last entry
+func (c *Config) Get(key string) (string, bool) {
+	entries := c.GetAll(key)
+	if len(entries) == 0 {
+		return "", false
+	}
+	return entries[len(entries)-1].Value, true
+}
+
+// splitConfigKey splits "section.name" or "section.subsection.name".
+// The subsection may itself contain dots
+func splitConfigKey(key string) (section, subsection, name string, err error) {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first == -1 {
+		return "", "", "", fmt.Errorf("key does not contain a section: %s", key)
+	}
+	if last == len(key)-1 {
+		return "", "", "", fmt.Errorf("key does not contain variable name: %s", key)
+	}
+	section, name = key[:first], key[last+1:]
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+
+	valid := section != "" && isAlpha(name[0])
+	for i := 0; valid && i < len(section); i++ {
+		valid = isAlpha(section[i]) || isDigit(section[i]) || section[i] == '-'
+	}
+	for i := 0; valid && i < len(name); i++ {
+		valid = isAlpha(name[i]) || isDigit(name[i]) || name[i] == '-'
+	}
+	if !valid || strings.Contains(subsection, "\n") {
+		return "", "", "", fmt.Errorf("invalid key: %s", key)
+	}
+	return section, subsection, name, nil
+}
+
+// canonicalConfigKey joins a key with its section and name lowercased.
+// The subsection is the only case sensitive part
+func canonicalConfigKey(section, subsection, name string) string {
+	key := strings.ToLower(section)
+	if subsection != "" {
+		key += "." + subsection
+	}
+	return key + "." + strings.ToLower(name)
+}
+
+// loadConfigOrWarn reads the configuration for callers that only consult
+// it. Problems reading it are reported and treated as an empty configuration
+func loadConfigOrWarn() *Config {
+	config, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		return &Config{}
+	}
+	return config
+}
+
+// expandHome replaces a leading "~/" with the home directory
+func expandHome(path string) string {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+type ConfigCommand struct{}
+
+func (c *ConfigCommand) GetName() string {
+	return "config"
+}
+
+func (c *ConfigCommand) Execute(cmd *Command) error {
+	// Format: config [--system|--global|--local|-f <file>] [--show-origin]
+	//         [--[no-]includes] (--get <key> | --get-all <key> | --set <key> <value> |
+	//          --unset <key> | -l|--list | <key> [<value>])
+	var action, scope, file string
+	showOrigin := false
+	includes := ""
+	var positional []string
+
+	args := cmd.Args
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+
+		switch arg {
+		case "--get", "--get-all", "--set", "--unset", "--list", "-l":
+			if action != "" {
+				return fmt.Errorf("only one action at a time")
+			}
+			action = strings.TrimLeft(arg, "-")
+			if action == "l" {
+				action = "list"
+			}
+		case "--system":
+			scope = configScopeSystem
+		case "--global":
+			scope = configScopeGlobal
+		case "--local":
+			scope = configScopeLocal
+		case "-f", "--file":
+			if len(args) == 0 {
+				return fmt.Errorf("option '%s' requires a value", arg)
+			}
+			file, args = args[0], args[1:]
+		case "--show-origin":
+			showOrigin = true
+		case "--includes", "--no-includes":
+			includes = arg
+		default:
+			if value, found := strings.CutPrefix(arg, "--file="); found {
+				file = value
+			} else if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			} else {
+				positional = append(positional, arg)
+			}
+		}
+	}
+
+	// Without an action, one argument reads a key and two set it
+	if action == "" {
+		switch len(positional) {
+		case 1:
+			action = "get"
+		case 2:
+			action = "set"
+		default:
+			return fmt.Errorf("usage: config [<file-option>] (--get <key> | --get-all <key> | --set <key> <value> | --unset <key> | --list)")
+		}
+	}
+	wantArgs := map[string]int{"get": 1, "get-all": 1, "set": 2, "unset": 1, "list": 0}[action]
+	if len(positional) != wantArgs {
+		return fmt.Errorf("wrong number of arguments for --%s, should be %d", action, wantArgs)
+	}
+
+	if file != "" && scope != "" {
+		return fmt.Errorf("only one config file at a time")
+	}
+	switch scope {
+	case configScopeSystem:
+		file = systemConfigFile()
+	case configScopeGlobal:
+		// Reading covers both global files, writing only one
+		if action == "set" || action == "unset" {
+			var err error
+			if file, err = globalConfigWriteFile(); err != nil {
+				return err
+			}
+		}
+	case configScopeLocal:
+		file = localConfigFile
+	}
+
+	switch action {
+	case "set", "unset":
+		if file == "" {
+			file = localConfigFile
+		}
+		config, err := readConfigFile(file)
+		if err != nil {
+			return err
+		}
+		if action == "set" {
+			return config.set(positional[0], positional[1])
+		}
+		return config.unset(positional[0])
+	}
+
+	// Includes are only followed by default when reading every file
+	followIncludes := includes == "--includes" || (includes == "" && file == "" && scope == "")
+
+	var config *Config
+	var err error
+	switch {
+	case file != "":
+		if _, statErr := os.Stat(file); statErr != nil && action == "list" {
+			return fmt.Errorf("unable to read config file '%s': %w", file, statErr)
+		}
+		config, err = LoadConfigFile(file, configScopeCommand, followIncludes)
+	case scope == configScopeGlobal:
+		config = &Config{skipIncludes: !followIncludes}
+		for _, path := range globalConfigFiles() {
+			if err = config.loadFile(path, configScopeGlobal, 0); err != nil {
+				break
+			}
+		}
+	default:
+		config = &Config{skipIncludes: !followIncludes}
+		err = config.loadAll()
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []*ConfigEntry
+	switch action {
+	case "list":
+		entries = config.Entries
+	case "get", "get-all":
+		if _, _, _, err := splitConfigKey(positional[0]); err != nil {
+			return err
+		}
+		entries = config.GetAll(positional[0])
+		if len(entries) == 0 {
+			return ErrExitStatus
+		}
+		if action == "get" {
+			entries = entries[len(entries)-1:]
+		}
+	}
+
+	for _, entry := range entries {
+		if showOrigin {
+			fmt.Printf("%s\t", entry.Origin())
+		}
+		switch {
+		case action != "list":
+			fmt.Println(entry.Value)
+		case entry.NoValue:
+			fmt.Println(entry.Key)
+		default:
+			fmt.Printf("%s=%s\n", entry.Key, entry.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// configFile is one parsed config file. Positions are kept so that
+// variables can be changed without rewriting the rest of the file
+type configFile struct {
+	path      string
+	data      []byte
+	sections  []*configSection
+	variables []*configVariable
+}
+
+// configSection is a "[section]" or `[section "subsection"]` header and
+// the lines following it
+type configSection struct {
+	name       string // lowercased
+	subsection string // case sensitive, except in the legacy [section.subsection] form
+	start      int    // offset of the header line
+	end        int    // offset just past the last line that belongs to the section
+}
+
+// key returns the section part of a variable key
+func (s *configSection) key() string {
+	if s.subsection == "" {
+		return s.name
+	}
+	return s.name + "." + s.subsection
+}
+
+// configVariable is one "name = value" line, or a bare "name" meaning true
+type configVariable struct {
+	section *configSection
+	name    string // lowercased
+	value   string
+	noValue bool
+	start   int // offset of the line it starts on
+	end     int // offset just past the line it ends on
+}
+
+// key returns the canonical "section.subsection.name" key
+func (v *configVariable) key() string {
+	return v.section.key() + "." + v.name
+}
+
+// readConfigFile reads and parses a config file. A missing file is empty
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file '%s': %w", path, err)
+	}
+	return parseConfigFile(path, data)
+}
+
+// configParser walks the bytes of a config file
+type configParser struct {
+	data      []byte
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (p *configParser) next() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+		p.lineStart = p.pos
+	}
+	return c, true
+}
+
+func (p *configParser) peek() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	return p.data[p.pos], true
+}
+
+// skipLine moves past the end of the current line
+func (p *configParser) skipLine() {
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+// itemStart is where an item starting at offset begins for editing: the
+// start of its line, unless something else precedes it on that line
+func (p *configParser) itemStart(offset int) int {
+	if len(bytes.TrimSpace(p.data[p.lineStart:offset])) == 0 {
+		return p.lineStart
+	}
+	return offset
+}
+
+// parseConfigFile parses git's INI dialect: "[section]" and
+// `[section "subsection"]` headers, "name = value" lines, "#" and ";"
+// comments, double quotes, backslash escapes and continuation lines
+func parseConfigFile(path string, data []byte) (*configFile, error) {
+	file := &configFile{path: path, data: data}
+	p := &configParser{data: data, line: 1}
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		p.pos, p.lineStart = 3, 3
+	}
+
+	// Errors are reported at the line the item starts on
+	badLine := func(line int) error {
+		return fmt.Errorf("bad config line %d in file %s", line, path)
+	}
+
+	var current *configSection
+	for {
+		line := p.line
+		c, ok := p.next()
+		if !ok {
+			break
+		}
+
+		switch {
+		case c == '\n' || isConfigSpace(c):
+		case c == '#' || c == ';':
+			p.skipLine()
+
+		case c == '[':
+			start := p.itemStart(p.pos - 1)
+			section, err := p.parseSectionHeader()
+			if err != nil {
+				return nil, badLine(line)
+			}
+			section.start = start
+			section.end = p.pos
+			if newline := bytes.IndexByte(data[p.pos:], '\n'); newline >= 0 {
+				section.end += newline + 1
+			} else {
+				section.end = len(data)
+			}
+			file.sections = append(file.sections, section)
+			current = section
+
+		case isAlpha(c):
+			if current == nil {
+				return nil, badLine(line)
+			}
+			variable := &configVariable{section: current, start: p.itemStart(p.pos - 1)}
+			if err := p.parseVariable(variable, c); err != nil {
+				return nil, badLine(line)
+			}
+			variable.end = p.pos
+			current.end = max(current.end, p.pos)
+			file.variables = append(file.variables, variable)
+
+		default:
+			return nil, badLine(line)
+		}
+	}
+
+	return file, nil
+}
+
+// parseSectionHeader parses what follows a "["
+func (p *configParser) parseSectionHeader() (*configSection, error) {
+	var name strings.Builder
+	for {
+		c, ok := p.next()
+		if !ok {
+			return nil, fmt.Errorf("unterminated section header")
+		}
+		if c == ']' {
+			break
+		}
+		if isConfigSpace(c) {
+			return p.parseSubsection(name.String())
+		}
+		if !isAlpha(c) && !isDigit(c) && c != '-' && c != '.' {
+			return nil, fmt.Errorf("invalid section name")
+		}
+		name.WriteByte(c)
+	}
+
+	// The legacy [section.subsection] form is case insensitive throughout
+	section := &configSection{name: strings.ToLower(name.String())}
+	section.name, section.subsection, _ = strings.Cut(section.name, ".")
+	if section.name == "" {
+		return nil, fmt.Errorf("empty section name")
+	}
+	return section, nil
+}
+
+// parseSubsection parses the `"subsection"]` part of a header
+func (p *configParser) parseSubsection(name string) (*configSection, error) {
+	if name == "" || strings.Contains(name, ".") {
+		return nil, fmt.Errorf("invalid section name")
+	}
+
+	c, ok := p.next()
+	for ok && isConfigSpace(c) {
+		c, ok = p.next()
+	}
+	if c != '"' {
+		return nil, fmt.Errorf("missing subsection")
+	}
+
+	var subsection strings.Builder
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return nil, fmt.Errorf("unterminated subsection")
+		}
+		if c == '"' {
+			break
+		}
+		// A backslash keeps the next character, whatever it is
+		if c == '\\' {
+			if c, ok = p.next(); !ok || c == '\n' {
+				return nil, fmt.Errorf("unterminated subsection")
+			}
+		}
+		subsection.WriteByte(c)
+	}
+
+	if c, _ := p.next(); c != ']' {
+		return nil, fmt.Errorf("unterminated section header")
+	}
+	return &configSection{name: strings.ToLower(name), subsection: subsection.String()}, nil
+}
+
+// parseVariable parses a variable line whose first character is first
+func (p *configParser) parseVariable(variable *configVariable, first byte) error {
+	name := []byte{first}
+	for {
+		c, ok := p.peek()
+		if !ok || (!isAlpha(c) && !isDigit(c) && c != '-') {
+			break
+		}
+		name = append(name, c)
+		p.next()
+	}
+	variable.name = strings.ToLower(string(name))
+
+	c, ok := p.next()
+	for ok && c != '\n' && isConfigSpace(c) {
+		c, ok = p.next()
+	}
+	switch {
+	case !ok || c == '\n':
+		variable.noValue = true
+		return nil
+	case c == '#' || c == ';':
+		variable.noValue = true
+		p.skipLine()
+		return nil
+	case c != '=':
+		return fmt.Errorf("invalid variable name")
+	}
+
+	value, err := p.parseValue()
+	variable.value = value
+	return err
+}
+
+// parseValue parses a value up to the end of its (last) line. Whitespace
+// outside quotes is trimmed at both ends, and each character of it inside
+// the value is kept as a space
+func (p *configParser) parseValue() (string, error) {
+	var value strings.Builder
+	quoted, comment := false, false
+	spaces := 0
+
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return "", fmt.Errorf("unterminated quote")
+			}
+			return value.String(), nil
+		}
+		if comment {
+			continue
+		}
+		if isConfigSpace(c) && !quoted {
+			if value.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		if !quoted && (c == '#' || c == ';') {
+			comment = true
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			value.WriteByte(' ')
+		}
+
+		switch c {
+		case '\\':
+			c, _ = p.next()
+			switch c {
+			case '\n':
+				// Continuation line
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return "", fmt.Errorf("invalid escape")
+			}
+			value.WriteByte(c)
+		case '"':
+			quoted = !quoted
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// set changes the last value of a key, or adds it at the end of its
+// section, creating the section if needed. A key with several values is
+// not overwritten, as it is unclear which one is meant
+func (f *configFile) set(key, value string) error {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	canonical := canonicalConfigKey(section, subsection, name)
+	line := "\t" + name + " = " + quoteConfigValue(value) + "\n"
+
+	var matches []*configVariable
+	for _, variable := range f.variables {
+		if variable.key() == canonical {
+			matches = append(matches, variable)
+		}
+	}
+	if len(matches) > 1 {
+		return fmt.Errorf("cannot overwrite multiple values with a single value\n       Use a regexp, --add or --replace-all to change %s.", key)
+	}
+	if len(matches) == 1 {
+		return f.splice(matches[0].start, matches[0].end, line)
+	}
+
+	sectionKey := canonicalConfigKey(section, subsection, "")
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		if f.sections[i].key()+"." == sectionKey {
+			return f.splice(f.sections[i].end, f.sections[i].end, ensureNewline(f.data, f.sections[i].end)+line)
+		}
+	}
+
+	header := "[" + section + "]\n"
+	if subsection != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+		header = "[" + section + ` "` + escaped + "\"]\n"
+	}
+	return f.splice(len(f.data), len(f.data), ensureNewline(f.data, len(f.data))+header+line)
+}
+
+// unset removes a key. Sections left without variables are removed with
+// it. It returns ErrExitStatus when the key is not set
+func (f *configFile) unset(key string) error {
+	section, subsection, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	canonical := canonicalConfigKey(section, subsection, name)
+
+	var match *configVariable
+	for _, variable := range f.variables {
+		if variable.key() != canonical {
+			continue
+		}
+		if match != nil {
+			return fmt.Errorf("%s has multiple values", key)
+		}
+		match = variable
+	}
+	if match == nil {
+		return ErrExitStatus
+	}
+
+	start, end := match.start, match.end
+	empty := true
+	for _, variable := range f.variables {
+		if variable != match && variable.section == match.section {
+			empty = false
+			break
+		}
+	}
+	if empty && match.section.end == match.end {
+		start = match.section.start
+	}
+	return f.splice(start, end, "")
+}
+
+// splice replaces data[start:end] and writes the file back
+func (f *configFile) splice(start, end int, text string) error {
+	var data []byte
+	data = append(data, f.data[:start]...)
+	data = append(data, text...)
+	data = append(data, f.data[end:]...)
+	return writeFileLocked(f.path, data)
+}
+
+// ensureNewline returns the newline needed before inserting at offset,
+// when the preceding line is not terminated
+func ensureNewline(data []byte, offset int) string {
+	if offset > 0 && data[offset-1] != '\n' {
+		return "\n"
+	}
+	return ""
+}
+
+// quoteConfigValue escapes a value for writing, quoting it when
+// surrounding whitespace or comment characters would otherwise be lost
+func quoteConfigValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\b", `\b`).Replace(value)
+	if value != "" && (isConfigSpace(value[0]) || isConfigSpace(value[len(value)-1]) || strings.ContainsAny(value, "#;")) {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
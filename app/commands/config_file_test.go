@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// configPair is a parsed variable as the tests compare it
+type configPair struct {
+	key, value string
+	noValue    bool
+}
+
+func configPairs(file *configFile) []configPair {
+	var pairs []configPair
+	for _, variable := range file.variables {
+		pairs = append(pairs, configPair{variable.key(), variable.value, variable.noValue})
+	}
+	return pairs
+}
+
+func equalConfigPairs(a, b []configPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []configPair
+	}{
+		{
+			name:  "sections and names are case insensitive",
+			input: "[Core]\n\tBare = true\n[core]\n\tfilemode=false\n",
+			want:  []configPair{{"core.bare", "true", false}, {"core.filemode", "false", false}},
+		},
+		{
+			name:  "subsections keep their case and escapes",
+			input: "[remote \"Origin\"]\n\turl = x\n[branch \"a\\\"b\\\\c\"]\n\tmerge = y\n",
+			want:  []configPair{{"remote.Origin.url", "x", false}, {`branch.a"b\c.merge`, "y", false}},
+		},
+		{
+			name:  "legacy dotted section is lowercased",
+			input: "[Branch.Main]\n\tremote = origin\n",
+			want:  []configPair{{"branch.main.remote", "origin", false}},
+		},
+		{
+			name:  "bare names and empty values",
+			input: "[core]\n\tbare\n\tempty =\n\tcommented # note\n",
+			want:  []configPair{{"core.bare", "", true}, {"core.empty", "", false}, {"core.commented", "", true}},
+		},
+		{
+			name:  "comments and surrounding whitespace",
+			input: "# top\n; also\n[a]\n\tx =   one  two   # trailing\n\ty = three;four\n",
+			want:  []configPair{{"a.x", "one  two", false}, {"a.y", "three", false}},
+		},
+		{
+			name:  "quotes keep whitespace and comment characters",
+			input: "[a]\n\tx = \"  padded # not a comment ; \"\n\ty = half\" quoted \"text\n",
+			want:  []configPair{{"a.x", "  padded # not a comment ; ", false}, {"a.y", "half quoted text", false}},
+		},
+		{
+			name:  "escapes",
+			input: "[a]\n\tx = tab\\there\\nnewline\\\\back\\\"quote\\bbs\n",
+			want:  []configPair{{"a.x", "tab\there\nnewline\\back\"quote\bbs", false}},
+		},
+		{
+			name:  "continuation lines",
+			input: "[a]\n\tx = first \\\n  second\n\ty = last\n",
+			want:  []configPair{{"a.x", "first   second", false}, {"a.y", "last", false}},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "[a]\r\n\tx = value\r\n",
+			want:  []configPair{{"a.x", "value", false}},
+		},
+		{
+			name:  "byte order mark",
+			input: "\xef\xbb\xbf[a]\n\tx = 1\n",
+			want:  []configPair{{"a.x", "1", false}},
+		},
+		{
+			name:  "header and variable on one line",
+			input: "[a] x = 1\n",
+			want:  []configPair{{"a.x", "1", false}},
+		},
+		{
+			name:  "no trailing newline",
+			input: "[a]\n\tx = 1",
+			want:  []configPair{{"a.x", "1", false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parseConfigFile("test", []byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseConfigFile: %v", err)
+			}
+			if got := configPairs(file); !equalConfigPairs(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"variable before any section", "x = 1\n", 1},
+		{"unterminated section header", "[core\n", 1},
+		{"invalid section name", "[co_re]\n", 1},
+		{"empty section name", "[]\n", 1},
+		{"subsection without quotes", "[remote origin]\n", 1},
+		{"unterminated subsection", "[remote \"origin]\n", 1},
+		{"dotted name with subsection", "[a.b \"c\"]\n", 1},
+		{"unterminated quote", "[a]\n\tx = \"open\n", 2},
+		{"invalid escape", "[a]\n\tx = \\q\n", 2},
+		{"invalid variable name", "[a]\n\tx! = 1\n", 2},
+		{"name starting with a digit", "[a]\n\t1x = 1\n", 2},
+		{"reported on the line the value starts", "[a]\n\tok = 1\n\tx = one \\\n two \\q\n", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfigFile("cfg", []byte(tt.input))
+			want := fmt.Sprintf("bad config line %d in file cfg", tt.line)
+			if err == nil || err.Error() != want {
+				t.Errorf("error = %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+// writeTestConfig writes content to a config file in a temporary directory
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileSetAndUnset(t *testing.T) {
+	const original = "# keep me\n[core]\n\tbare = false ; comment\n[remote \"origin\"]\n\turl = x\n"
+
+	tests := []struct {
+		name   string
+		input  string
+		edit   func(*configFile) error
+		output string
+	}{
+		{
+			name:   "replace a value in place",
+			input:  original,
+			edit:   func(f *configFile) error { return f.set("core.bare", "true") },
+			output: "# keep me\n[core]\n\tbare = true\n[remote \"origin\"]\n\turl = x\n",
+		},
+		{
+			name:   "append to an existing section",
+			input:  original,
+			edit:   func(f *configFile) error { return f.set("remote.origin.fetch", "+refs/*") },
+			output: original + "\tfetch = +refs/*\n",
+		},
+		{
+			name:   "add a section",
+			input:  original,
+			edit:   func(f *configFile) error { return f.set(`branch.a"b.merge`, "m") },
+			output: original + "[branch \"a\\\"b\"]\n\tmerge = m\n",
+		},
+		{
+			name:   "file without a trailing newline",
+			input:  "[core]\n\tbare = false",
+			edit:   func(f *configFile) error { return f.set("core.x", "1") },
+			output: "[core]\n\tbare = false\n\tx = 1\n",
+		},
+		{
+			name:   "unset keeps a section that still has variables",
+			input:  "[a]\n\tx = 1\n\ty = 2\n",
+			edit:   func(f *configFile) error { return f.unset("a.x") },
+			output: "[a]\n\ty = 2\n",
+		},
+		{
+			name:   "unset removes a section left empty",
+			input:  original,
+			edit:   func(f *configFile) error { return f.unset("remote.origin.url") },
+			output: "# keep me\n[core]\n\tbare = false ; comment\n",
+		},
+		{
+			name:   "unset a continued value",
+			input:  "[a]\n\tx = one \\\n two\n\ty = 2\n",
+			edit:   func(f *configFile) error { return f.unset("a.x") },
+			output: "[a]\n\ty = 2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestConfig(t, tt.input)
+			file, err := readConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.edit(file); err != nil {
+				t.Fatalf("edit: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.output {
+				t.Errorf("file is\n%q\nwant\n%q", data, tt.output)
+			}
+			if _, err := os.Stat(path + ".lock"); err == nil {
+				t.Errorf("lock file left behind")
+			}
+		})
+	}
+}
+
+func TestConfigFileEditErrors(t *testing.T) {
+	path := writeTestConfig(t, "[a]\n\tx = 1\n\tx = 2\n")
+	file, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.set("a.x", "3"); err == nil {
+		t.Errorf("set of a multi-valued key succeeded")
+	}
+	if err := file.unset("a.x"); err == nil {
+		t.Errorf("unset of a multi-valued key succeeded")
+	}
+	if err := file.unset("a.missing"); err != ErrExitStatus {
+		t.Errorf("unset of a missing key = %v, want ErrExitStatus", err)
+	}
+	for _, key := range []string{"nosection", "a.", "a.1x", "a b.x"} {
+		if err := file.set(key, "v"); err == nil {
+			t.Errorf("set(%q) succeeded", key)
+		}
+	}
+}
+
+// Values written by set must parse back to exactly the same value
+func TestConfigValueRoundTrip(t *testing.T) {
+	values := []string{
+		"plain",
+		"",
+		"  leading and trailing  ",
+		"hash # and ; semicolon",
+		`back\slash and "quotes"`,
+		"tab\tnewline\nbackspace\b",
+		"\t",
+	}
+
+	for _, value := range values {
+		path := writeTestConfig(t, "")
+		file, err := readConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.set("a.b.c", value); err != nil {
+			t.Fatalf("set(%q): %v", value, err)
+		}
+
+		reread, err := readConfigFile(path)
+		if err != nil {
+			t.Fatalf("re-reading after set(%q): %v", value, err)
+		}
+		want := []configPair{{"a.b.c", value, false}}
+		if got := configPairs(reread); !equalConfigPairs(got, want) {
+			t.Errorf("set(%q) read back as %+v", value, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package commands
+
+import "bytes"
+
+// Matches shorter than this are not worth a copy instruction
+const deltaBlockSize = 16
+
+// Largest copy a single instruction is used for, as in git
+const maxDeltaCopy = 0x10000
+
+// Largest insert a single instruction can carry
+const maxDeltaInsert = 0x7f
+
+// createDelta encodes target as copy and insert instructions against base,
+// in the format applyDelta reads
+func createDelta(base, target []byte) []byte {
+	var out bytes.Buffer
+	writeDeltaSize(&out, len(base))
+	writeDeltaSize(&out, len(target))
+
+	// Index the base in fixed blocks; later blocks win, which keeps
+	// copies close to the end of the base like git does
+	index := make(map[string]int, len(base)/deltaBlockSize)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		index[string(base[i:i+deltaBlockSize])] = i
+	}
+
+	insertStart := 0
+	i := 0
+	for i+deltaBlockSize <= len(target) {
+		baseOffset, found := index[string(target[i:i+deltaBlockSize])]
+		if !found {
+			i++
+			continue
+		}
+
+		// Grow the match forwards, then backwards into the pending insert
+		length := deltaBlockSize
+		for baseOffset+length < len(base) && i+length < len(target) && base[baseOffset+length] == target[i+length] {
+			length++
+		}
+		for baseOffset > 0 && i > insertStart && base[baseOffset-1] == target[i-1] {
+			baseOffset--
+			i--
+			length++
+		}
+
+		writeDeltaInsert(&out, target[insertStart:i])
+		writeDeltaCopy(&out, baseOffset, length)
+		i += length
+		insertStart = i
+	}
+	writeDeltaInsert(&out, target[insertStart:])
+
+	return out.Bytes()
+}
+
+// writeDeltaSize writes a size in the little-endian varint used by delta
+// headers; it is the inverse of readDeltaSize
+func writeDeltaSize(out *bytes.Buffer, size int) {
+	for size >= 0x80 {
+		out.WriteByte(byte(size&0x7f) | 0x80)
+		size >>= 7
+	}
+	out.WriteByte(byte(size))
+}
+
+// writeDeltaInsert emits insert instructions for data
+func writeDeltaInsert(out *bytes.Buffer, data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), maxDeltaInsert)
+		out.WriteByte(byte(n))
+		out.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+// writeDeltaCopy emits copy instructions for base[offset:offset+length].
+// Only the non-zero bytes of offset and size are stored, each announced
+// by a bit in the command byte
+func writeDeltaCopy(out *bytes.Buffer, offset, length int) {
+	for length > 0 {
+		size := min(length, maxDeltaCopy)
+
+		cmd := byte(0x80)
+		var args []byte
+		for i := 0; i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				cmd |= 1 << i
+				args = append(args, b)
+			}
+		}
+		// A size of 0x10000 is encoded by leaving all size bytes out
+		if size != maxDeltaCopy {
+			for i := 0; i < 3; i++ {
+				if b := byte(size >> (8 * i)); b != 0 {
+					cmd |= 0x10 << i
+					args = append(args, b)
+				}
+			}
+		}
+
+		out.WriteByte(cmd)
+		out.Write(args)
+		offset += size
+		length -= size
+	}
+}
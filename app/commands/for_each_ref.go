@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format used when --format is not given
+const defaultRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+type ForEachRefCommand struct{}
+
+func (c *ForEachRefCommand) GetName() string {
+	return "for-each-ref"
+}
+
+func (c *ForEachRefCommand) Execute(cmd *Command) error {
+	// Format: for-each-ref [--format=<format>] [--sort=<key>]... [--count=<n>] [<pattern>...]
+	format := defaultRefFormat
+	var sortKeys, patterns []string
+	count := 0
+
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if (arg == "--format" || arg == "--sort" || arg == "--count") && i+1 < len(cmd.Args) {
+			name, value, hasValue = arg, cmd.Args[i+1], true
+			i++
+		}
+
+		switch {
+		case name == "--format" && hasValue:
+			format = value
+		case name == "--sort" && hasValue:
+			sortKeys = append(sortKeys, value)
+		case name == "--count" && hasValue:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid --count argument: `%s'", value)
+			}
+			count = n
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+
+	atoms, err := parseRefFormat(format)
+	if err != nil {
+		return err
+	}
+	for _, key := range sortKeys {
+		if _, err := parseRefFormat("%(" + strings.TrimPrefix(key, "-") + ")"); err != nil {
+			return err
+		}
+	}
+
+	refs, err := refStore.List("refs/")
+	if err != nil {
+		return err
+	}
+	head, _ := refStore.ReadSymbolic("HEAD")
+
+	var items []*refFormatItem
+	for _, ref := range refs {
+		if forEachRefMatch(ref.Name, patterns) {
+			items = append(items, &refFormatItem{ref: ref, head: head, values: make(map[string]string)})
+		}
+	}
+
+	// The last --sort key is the primary one, so keys are applied in order
+	// with a stable sort. Without any, refs are sorted by name
+	if len(sortKeys) == 0 {
+		sortKeys = []string{"refname"}
+	}
+	for _, key := range sortKeys {
+		atom, descending := strings.CutPrefix(key, "-")
+		values := make(map[*refFormatItem]string, len(items))
+		for _, item := range items {
+			if values[item], err = item.value(atom); err != nil {
+				return err
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := values[items[i]], values[items[j]]
+			if descending {
+				a, b = b, a
+			}
+			if atom == "objectsize" {
+				sizeA, _ := strconv.Atoi(a)
+				sizeB, _ := strconv.Atoi(b)
+				return sizeA < sizeB
+			}
+			return a < b
+		})
+	}
+	if count > 0 && count < len(items) {
+		items = items[:count]
+	}
+
+	var out strings.Builder
+	for _, item := range items {
+		for _, atom := range atoms {
+			if !atom.isField {
+				out.WriteString(atom.text)
+				continue
+			}
+			value, err := item.value(atom.text)
+			if err != nil {
+				return err
+			}
+			out.WriteString(value)
+		}
+		out.WriteByte('\n')
+	}
+	fmt.Print(out.String())
+	return nil
+}
+
+// forEachRefMatch reports whether a ref matches one of the patterns,
+// either as a glob or literally up to a slash: "refs/heads" matches every
+// branch
+func forEachRefMatch(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		prefix := strings.TrimSuffix(pattern, "/")
+		if name == pattern || strings.HasPrefix(name, prefix+"/") || wildmatch(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// refFormatAtom is a literal piece of a format, or a %(field)
+type refFormatAtom struct {
+	text    string
+	isField bool
+}
+
+// refFormatFields are the fields --format and --sort understand
+var refFormatFields = map[string]bool{
+	"refname": true, "refname:short": true,
+	"objectname": true, "objectname:short": true,
+	"objecttype": true, "objectsize": true,
+	"*objectname": true, "*objecttype": true,
+	"symref": true, "symref:short": true,
+	"HEAD": true, "subject": true,
+}
+
+// parseRefFormat splits a format into literal text and fields. "%%" is a
+// percent sign and "%xx" the byte with that hex value
+func parseRefFormat(format string) ([]refFormatAtom, error) {
+	var atoms []refFormatAtom
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			atoms = append(atoms, refFormatAtom{text: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			literal.WriteByte(c)
+			continue
+		}
+
+		switch rest := format[i+1:]; {
+		case rest[0] == '%':
+			literal.WriteByte('%')
+			i++
+		case rest[0] == '(':
+			end := strings.IndexByte(rest, ')')
+			if end == -1 {
+				return nil, fmt.Errorf("malformed format string %s", format)
+			}
+			field := rest[1:end]
+			if !refFormatFields[field] && !strings.HasPrefix(field, "refname:lstrip=") {
+				return nil, fmt.Errorf("unknown field name: %s", field)
+			}
+			flush()
+			atoms = append(atoms, refFormatAtom{text: field, isField: true})
+			i += end + 1
+		default:
+			if b, err := hex.DecodeString(rest[:min(2, len(rest))]); err == nil && len(b) == 1 {
+				literal.WriteByte(b[0])
+				i += 2
+			} else {
+				literal.WriteByte(c)
+			}
+		}
+	}
+	flush()
+	return atoms, nil
+}
+
+// refFormatItem is a ref being formatted, with the fields computed so far
+type refFormatItem struct {
+	ref    *Ref
+	head   string // the branch HEAD points at
+	values map[string]string
+
+	objectType GitObjectType
+	content    []byte
+}
+
+// object reads the object the ref points at, once
+func (item *refFormatItem) object() error {
+	if item.objectType != "" {
+		return nil
+	}
+	var err error
+	item.objectType, item.content, err = ReadObject(item.ref.SHA)
+	if err != nil {
+		return fmt.Errorf("missing object %s for %s", item.ref.SHA, item.ref.Name)
+	}
+	return nil
+}
+
+// value computes a field for the ref
+func (item *refFormatItem) value(field string) (string, error) {
+	if value, cached := item.values[field]; cached {
+		return value, nil
+	}
+
+	ref := item.ref
+	var value string
+	switch field {
+	case "refname":
+		value = ref.Name
+	case "refname:short":
+		value = shortenRefName(ref.Name)
+	case "objectname":
+		value = ref.SHA
+	case "objectname:short":
+		value = ref.SHA[:7]
+	case "symref":
+		value = ref.Target
+	case "symref:short":
+		value = shortenRefName(ref.Target)
+	case "HEAD":
+		value = " "
+		if ref.Name == item.head {
+			value = "*"
+		}
+	case "objecttype", "objectsize", "subject":
+		if err := item.object(); err != nil {
+			return "", err
+		}
+		switch field {
+		case "objecttype":
+			value = string(item.objectType)
+		case "objectsize":
+			value = strconv.Itoa(len(item.content))
+		default:
+			value = objectSubject(item.objectType, item.content)
+		}
+	case "*objectname", "*objecttype":
+		// Only annotated tags have something to dereference to
+		if err := item.object(); err != nil {
+			return "", err
+		}
+		if item.objectType == TagObject {
+			peeled, peeledType, err := peelObject(ref.SHA)
+			if err != nil {
+				return "", err
+			}
+			value = peeled
+			if field == "*objecttype" {
+				value = string(peeledType)
+			}
+		}
+	default:
+		// refname:lstrip=<n> drops n leading components
+		n, err := strconv.Atoi(strings.TrimPrefix(field, "refname:lstrip="))
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("unknown field name: %s", field)
+		}
+		components := strings.Split(ref.Name, "/")
+		value = strings.Join(components[min(n, len(components)):], "/")
+	}
+
+	item.values[field] = value
+	return value, nil
+}
+
+// objectSubject returns the first paragraph of a commit or tag message,
+// joined into one line
+func objectSubject(objectType GitObjectType, content []byte) string {
+	if objectType != CommitObject && objectType != TagObject {
+		return ""
+	}
+	_, message, _ := strings.Cut(string(content), "\n\n")
+	paragraph, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	return strings.ReplaceAll(strings.TrimRight(paragraph, "\n"), "\n", " ")
+}
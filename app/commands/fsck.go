@@ -0,0 +1,359 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Modes a tree entry may have
+var validTreeModes = map[string]bool{
+	"100644": true,
+	"100755": true,
+	"120000": true,
+	"40000":  true,
+	"160000": true,
+}
+
+// identPattern matches "Name <email> <timestamp> <timezone>"
+var identPattern = regexp.MustCompile(`^[^<>\n]* <[^<>\n]*> [0-9]+ [+-][0-9]{4}$`)
+
+type FsckCommand struct{}
+
+func (c *FsckCommand) GetName() string {
+	return "fsck"
+}
+
+// objectLink is a reference from one object to another
+type objectLink struct {
+	sha     string
+	objType GitObjectType
+}
+
+// fsckObject is what fsck remembers about an object it has checked
+type fsckObject struct {
+	objType GitObjectType
+	links   []objectLink
+}
+
+// fsckChecker collects the objects of the repository and the problems found
+type fsckChecker struct {
+	objects  map[string]*fsckObject
+	problems int
+}
+
+func (c *FsckCommand) Execute(cmd *Command) error {
+	// Format: fsck [--no-dangling]
+	showDangling := true
+
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "--no-dangling":
+			showDangling = false
+		case "--dangling":
+			showDangling = true
+		default:
+			return fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	checker := &fsckChecker{objects: make(map[string]*fsckObject)}
+	if err := checker.checkObjects(); err != nil {
+		return err
+	}
+
+	roots, err := checker.checkRefs()
+	if err != nil {
+		return err
+	}
+	reachable := checker.walk(roots)
+
+	if showDangling {
+		checker.reportDangling(reachable)
+	}
+
+	if checker.problems > 0 {
+		return fmt.Errorf("fsck found %d problems", checker.problems)
+	}
+	return nil
+}
+
+// errorf reports a problem with the repository
+func (c *fsckChecker) errorf(format string, args ...any) {
+	c.problems++
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+}
+
+// checkObjects reads every loose and packed object, verifies its hash and
+// validates its content
+func (c *fsckChecker) checkObjects() error {
+	loose, err := listLooseObjects()
+	if err != nil {
+		return err
+	}
+	for _, object := range loose {
+		objectType, content, err := objectDB.readLoose(object.sha)
+		if err != nil {
+			c.errorf("%s: object corrupt or missing: %s", object.sha, err)
+			continue
+		}
+		if actual := HashGitObject(objectType, content); actual != object.sha {
+			c.errorf("hash mismatch for %s (expected %s, got %s)", object.path, object.sha, actual)
+			continue
+		}
+		c.checkObject(object.sha, objectType, content)
+	}
+
+	packs, err := objectDB.Packs()
+	if err != nil {
+		return err
+	}
+	for _, pack := range packs {
+		for _, entry := range pack.Index.Entries {
+			sha := hex.EncodeToString(entry.SHA[:])
+			if _, seen := c.objects[sha]; seen {
+				continue
+			}
+			// Read verifies the hash of the resolved object
+			objectType, content, err := pack.Read(entry.SHA)
+			if err != nil {
+				c.errorf("%s: object corrupt in %s: %s", sha, pack.Path, err)
+				continue
+			}
+			c.checkObject(sha, objectType, content)
+		}
+	}
+
+	return nil
+}
+
+// checkObject validates one object and records the objects it refers to
+func (c *fsckChecker) checkObject(sha string, objectType GitObjectType, content []byte) {
+	object := &fsckObject{objType: objectType}
+	c.objects[sha] = object
+
+	var err error
+	switch objectType {
+	case BlobObject:
+	case TreeObject:
+		object.links, err = fsckTree(content)
+	case CommitObject:
+		object.links, err = fsckCommit(content)
+	case TagObject:
+		object.links, err = fsckTag(content)
+	default:
+		err = fmt.Errorf("unknown object type %q", objectType)
+	}
+
+	if err != nil {
+		c.errorf("in %s %s: %s", objectType, sha, err)
+	}
+}
+
+// checkRefs returns the objects HEAD, the refs and the index point at,
+// reporting pointers to nothing
+func (c *fsckChecker) checkRefs() ([]string, error) {
+	refs, err := refStore.List("refs/")
+	if err != nil {
+		return nil, err
+	}
+	if head, err := refStore.Resolve("HEAD"); err == nil {
+		refs = append([]*Ref{{Name: "HEAD", SHA: head}}, refs...)
+	}
+
+	var roots []string
+	for _, ref := range refs {
+		if _, exists := c.objects[ref.SHA]; !exists {
+			c.errorf("%s: invalid sha1 pointer %s", ref.Name, ref.SHA)
+			continue
+		}
+		roots = append(roots, ref.SHA)
+	}
+
+	// Staged content is kept alive by the index, not by any ref
+	staged, err := indexRoots()
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range staged {
+		if _, exists := c.objects[sha]; !exists {
+			c.errorf("%s: invalid sha1 pointer in index", sha)
+			continue
+		}
+		roots = append(roots, sha)
+	}
+	return roots, nil
+}
+
+// walk follows links from the roots, reporting missing objects and links
+// to objects of the wrong type. It returns the set of reachable objects
+func (c *fsckChecker) walk(roots []string) map[string]bool {
+	reachable := make(map[string]bool)
+	missing := make(map[string]bool)
+	pending := append([]string(nil), roots...)
+
+	for len(pending) > 0 {
+		sha := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[sha] {
+			continue
+		}
+		reachable[sha] = true
+
+		for _, link := range c.objects[sha].links {
+			target, exists := c.objects[link.sha]
+			if !exists {
+				if !missing[link.sha] {
+					missing[link.sha] = true
+					c.problems++
+					fmt.Printf("missing %s %s\n", link.objType, link.sha)
+				}
+				continue
+			}
+			if target.objType != link.objType {
+				c.errorf("%s %s links to %s, which is a %s, not a %s", c.objects[sha].objType, sha, link.sha, target.objType, link.objType)
+				continue
+			}
+			pending = append(pending, link.sha)
+		}
+	}
+
+	return reachable
+}
+
+// reportDangling prints unreachable objects that no other object refers to
+func (c *fsckChecker) reportDangling(reachable map[string]bool) {
+	referenced := make(map[string]bool)
+	for _, object := range c.objects {
+		for _, link := range object.links {
+			referenced[link.sha] = true
+		}
+	}
+
+	var dangling []string
+	for sha := range c.objects {
+		if !reachable[sha] && !referenced[sha] {
+			dangling = append(dangling, sha)
+		}
+	}
+	sort.Strings(dangling)
+
+	for _, sha := range dangling {
+		fmt.Printf("dangling %s %s\n", c.objects[sha].objType, sha)
+	}
+}
+
+// fsckTree validates tree entries: known modes, sensible names, canonical
+// order and no duplicates
+func fsckTree(content []byte) ([]objectLink, error) {
+	entries, err := parseTreeEntries(content)
+	if err != nil {
+		return nil, fmt.Errorf("badTree: %w", err)
+	}
+
+	var links []objectLink
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if !validTreeModes[entry.Mode] {
+			return nil, fmt.Errorf("badFilemode: contains bad file mode %q", entry.Mode)
+		}
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." || strings.Contains(entry.Name, "/") {
+			return nil, fmt.Errorf("badTreeEntryName: contains bad entry name %q", entry.Name)
+		}
+		if isDotGitName(entry.Name) {
+			return nil, fmt.Errorf("hasDotgit: contains '.git'")
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("duplicateEntries: contains duplicate file entries %q", entry.Name)
+		}
+		names[entry.Name] = true
+		if i > 0 && !treeEntryLess(entries[i-1], entry) {
+			return nil, fmt.Errorf("treeNotSorted: not properly sorted at %q", entry.Name)
+		}
+
+		sha := hex.EncodeToString(entry.SHA)
+		switch entry.Mode {
+		case "40000":
+			links = append(links, objectLink{sha: sha, objType: TreeObject})
+		case "160000":
+			// Gitlinks point into another repository
+		default:
+			links = append(links, objectLink{sha: sha, objType: BlobObject})
+		}
+	}
+
+	return links, nil
+}
+
+// fsckCommit validates the header order of a commit: one tree, any number
+// of parents, then author and committer identities
+func fsckCommit(content []byte) ([]objectLink, error) {
+	headers, _, found := strings.Cut(string(content), "\n\n")
+	if !found {
+		return nil, fmt.Errorf("missingMessage: no blank line after the headers")
+	}
+	lines := strings.Split(headers, "\n")
+
+	var links []objectLink
+	next := func(key string) (string, bool) {
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], key+" ") {
+			return "", false
+		}
+		value := strings.TrimPrefix(lines[0], key+" ")
+		lines = lines[1:]
+		return value, true
+	}
+
+	tree, ok := next("tree")
+	if !ok {
+		return nil, fmt.Errorf("missingTree: invalid format - expected 'tree' line")
+	}
+	if !isObjectSHA(tree) {
+		return nil, fmt.Errorf("badTreeSha1: invalid 'tree' line format - bad sha1")
+	}
+	links = append(links, objectLink{sha: tree, objType: TreeObject})
+
+	for {
+		parent, ok := next("parent")
+		if !ok {
+			break
+		}
+		if !isObjectSHA(parent) {
+			return nil, fmt.Errorf("badParentSha1: invalid 'parent' line format - bad sha1")
+		}
+		links = append(links, objectLink{sha: parent, objType: CommitObject})
+	}
+
+	for _, key := range []string{"author", "committer"} {
+		ident, ok := next(key)
+		if !ok {
+			return nil, fmt.Errorf("missing%s: invalid format - expected '%s' line", strings.ToUpper(key[:1])+key[1:], key)
+		}
+		if !identPattern.MatchString(ident) {
+			return nil, fmt.Errorf("badIdent: invalid %s line %q", key, ident)
+		}
+	}
+
+	return links, nil
+}
+
+// fsckTag validates the headers of an annotated tag
+func fsckTag(content []byte) ([]objectLink, error) {
+	tag, err := ParseTag(content)
+	if err != nil {
+		return nil, fmt.Errorf("badTag: %w", err)
+	}
+	switch tag.Type {
+	case BlobObject, TreeObject, CommitObject, TagObject:
+	default:
+		return nil, fmt.Errorf("badType: invalid 'type' value %q", tag.Type)
+	}
+	if tag.Tagger != "" && !identPattern.MatchString(tag.Tagger) {
+		return nil, fmt.Errorf("badIdent: invalid tagger line %q", tag.Tagger)
+	}
+
+	return []objectLink{{sha: tag.Object, objType: tag.Type}}, nil
+}
@@ -0,0 +1,337 @@
+package commands
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unreachable loose objects younger than this survive gc by default
+const defaultPruneExpiry = "2.weeks.ago"
+
+type RepackCommand struct{}
+
+func (c *RepackCommand) GetName() string {
+	return "repack"
+}
+
+func (c *RepackCommand) Execute(cmd *Command) error {
+	// Format: repack [-d] [--window=<n>] [--depth=<n>]
+	opts := DefaultPackOptions
+	deleteRedundant := false
+
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == "-d":
+			deleteRedundant = true
+		case arg == "-a" || arg == "-A":
+			// Everything reachable always goes into the new pack
+		case strings.HasPrefix(arg, "--window="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--window="))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid window: %s", arg)
+			}
+			opts.Window = n
+		case strings.HasPrefix(arg, "--depth="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--depth="))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid depth: %s", arg)
+			}
+			opts.Depth = n
+		default:
+			return fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	_, err := repackReachable(opts, deleteRedundant)
+	return err
+}
+
+type GcCommand struct{}
+
+func (c *GcCommand) GetName() string {
+	return "gc"
+}
+
+func (c *GcCommand) Execute(cmd *Command) error {
+	// Format: gc [--aggressive] [--prune=<date> | --no-prune]
+	opts := DefaultPackOptions
+	expiry := defaultPruneExpiry
+
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == "--aggressive":
+			opts.Window = 250
+		case arg == "--no-prune":
+			expiry = "never"
+		case arg == "--prune":
+			expiry = defaultPruneExpiry
+		case strings.HasPrefix(arg, "--prune="):
+			expiry = strings.TrimPrefix(arg, "--prune=")
+		case arg == "--quiet" || arg == "-q":
+		default:
+			return fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	cutoff, err := parseExpiry(expiry, time.Now())
+	if err != nil {
+		return err
+	}
+
+	reachable, err := repackReachable(opts, true)
+	if err != nil {
+		return err
+	}
+
+	if !cutoff.IsZero() {
+		pruned, err := pruneLooseObjects(reachable, cutoff)
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			fmt.Printf("Pruned %d unreachable objects\n", pruned)
+		}
+	}
+
+	return refStore.Pack()
+}
+
+// repackReachable writes every object reachable from HEAD and the refs
+// into one new pack. With deleteRedundant, the old packs and the loose
+// objects now in the new pack are removed; unreachable objects from the
+// old packs are kept as loose objects so that pruning can age them out.
+// It returns the set of reachable objects
+func repackReachable(opts PackOptions, deleteRedundant bool) (map[string]bool, error) {
+	roots, err := refRoots()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := reachableObjects(roots)
+	if err != nil {
+		return nil, fmt.Errorf("error walking reachable objects: %w", err)
+	}
+
+	reachable := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		reachable[object.SHA] = true
+	}
+	if len(objects) == 0 {
+		fmt.Println("Nothing to pack")
+		return reachable, nil
+	}
+
+	oldPacks, err := objectDB.Packs()
+	if err != nil {
+		return nil, err
+	}
+
+	packDir := filepath.Join(objectDB.Dir, "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating pack directory: %w", err)
+	}
+	checksum, err := WritePackFiles(filepath.Join(packDir, "pack"), objects, opts)
+	if err != nil {
+		return nil, err
+	}
+	newPack := filepath.Join(packDir, "pack-"+checksum+".pack")
+	fmt.Printf("Packed %d objects into %s\n", len(objects), filepath.Base(newPack))
+
+	if !deleteRedundant {
+		return reachable, nil
+	}
+
+	for _, pack := range oldPacks {
+		if pack.Path == newPack {
+			continue
+		}
+		if err := unpackUnreachable(pack, reachable); err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(pack.Path, ".pack")
+		for _, ext := range []string{".idx", ".pack", ".bitmap", ".rev"} {
+			if err := os.Remove(base + ext); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("error removing old pack: %w", err)
+			}
+		}
+	}
+
+	removed, err := removePackedLooseObjects(reachable)
+	if err != nil {
+		return nil, err
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d loose objects\n", removed)
+	}
+
+	return reachable, nil
+}
+
+// unpackUnreachable writes the objects of a pack that nothing refers to as
+// loose objects, dated like the pack so they expire in due time
+func unpackUnreachable(pack *Packfile, reachable map[string]bool) error {
+	info, err := os.Stat(pack.Path)
+	if err != nil {
+		return fmt.Errorf("error reading pack: %w", err)
+	}
+
+	for _, entry := range pack.Index.Entries {
+		sha := hex.EncodeToString(entry.SHA[:])
+		if reachable[sha] {
+			continue
+		}
+		if _, err := os.Stat(objectDB.loosePath(sha)); err == nil {
+			continue
+		}
+
+		objectType, content, err := pack.Read(entry.SHA)
+		if err != nil {
+			return err
+		}
+		WriteGitObject(objectType, content, true)
+		if err := os.Chtimes(objectDB.loosePath(sha), info.ModTime(), info.ModTime()); err != nil {
+			return fmt.Errorf("error dating loose object %s: %w", sha, err)
+		}
+	}
+	return nil
+}
+
+// looseObject is a loose object file found under .git/objects
+type looseObject struct {
+	sha     string
+	path    string
+	modTime time.Time
+}
+
+// listLooseObjects returns every loose object in the database
+func listLooseObjects() ([]looseObject, error) {
+	dirs, err := os.ReadDir(objectDB.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %w", err)
+	}
+
+	var objects []looseObject
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(dir.Name()); err != nil {
+			continue
+		}
+
+		dirPath := filepath.Join(objectDB.Dir, dir.Name())
+		files, err := os.ReadDir(dirPath)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects: %w", err)
+		}
+		for _, file := range files {
+			sha := dir.Name() + file.Name()
+			if !isObjectSHA(sha) {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+			objects = append(objects, looseObject{sha: sha, path: filepath.Join(dirPath, file.Name()), modTime: info.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+// removePackedLooseObjects deletes loose copies of objects that are now packed
+func removePackedLooseObjects(packed map[string]bool) (int, error) {
+	objects, err := listLooseObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, object := range objects {
+		if !packed[object.sha] {
+			continue
+		}
+		if err := os.Remove(object.path); err != nil {
+			return removed, fmt.Errorf("error removing loose object %s: %w", object.sha, err)
+		}
+		os.Remove(filepath.Dir(object.path)) // only succeeds once the directory is empty
+		removed++
+	}
+	return removed, nil
+}
+
+// pruneLooseObjects deletes unreachable loose objects last modified before cutoff
+func pruneLooseObjects(reachable map[string]bool, cutoff time.Time) (int, error) {
+	objects, err := listLooseObjects()
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, object := range objects {
+		if reachable[object.sha] || !object.modTime.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(object.path); err != nil {
+			return pruned, fmt.Errorf("error pruning object %s: %w", object.sha, err)
+		}
+		os.Remove(filepath.Dir(object.path)) // only succeeds once the directory is empty
+		pruned++
+	}
+	return pruned, nil
+}
+
+// parseExpiry parses a gc.pruneExpire style date: "now", "never", a
+// relative "<n>.<unit>.ago", a Unix timestamp or an ISO date. "never"
+// returns the zero time
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		// Anything written up to this moment is old enough
+		return now.Add(time.Second), nil
+	}
+
+	if relative, isRelative := strings.CutSuffix(value, ".ago"); isRelative {
+		countText, unit, found := strings.Cut(relative, ".")
+		count, err := strconv.Atoi(countText)
+		if !found || err != nil || count < 0 {
+			return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+		}
+
+		switch strings.TrimSuffix(unit, "s") {
+		case "second":
+			return now.Add(-time.Duration(count) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(count) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(count) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -count), nil
+		case "week":
+			return now.AddDate(0, 0, -7*count), nil
+		case "month":
+			return now.AddDate(0, -count, 0), nil
+		case "year":
+			return now.AddDate(-count, 0, 0), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+	}
+
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math/bits"
 	"net/http"
 	"os"
 	"strconv"
@@ -20,6 +21,7 @@ const (
 	BlobObject   GitObjectType = "blob"
 	TreeObject   GitObjectType = "tree"
 	CommitObject GitObjectType = "commit"
+	TagObject    GitObjectType = "tag"
 )
 
 // WriteGitObject writes a Git object to the .git/objects directory
@@ -62,32 +64,33 @@ func WriteGitObject(objectType GitObjectType, content []byte, hexEncoded bool) [
 	return objSHA[:]
 }
 
-// ReadGitObject reads and decompresses a Git object from .git/objects
+// HashGitObject returns the hex SHA-1 a Git object would be stored under
+func HashGitObject(objectType GitObjectType, content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objectType, len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ReadGitObject reads a Git object from .git/objects, loose or packed
 // Returns the decompressed content (including header)
 func ReadGitObject(sha string) ([]byte, error) {
-	// Read from .git/objects/<first_2>/<remaining_38>
-	dirName := sha[:2]
-	fileName := sha[2:]
-	filePath := fmt.Sprintf(".git/objects/%s/%s", dirName, fileName)
-
-	content, err := os.ReadFile(filePath)
+	objectType, content, err := objectDB.Read(sha)
 	if err != nil {
 		return nil, fmt.Errorf("error reading git object: %w", err)
 	}
 
-	// Decompress using zlib
-	reader, err := zlib.NewReader(bytes.NewReader(content))
-	if err != nil {
-		return nil, fmt.Errorf("error creating zlib reader: %w", err)
-	}
-	defer reader.Close()
+	header := fmt.Sprintf("%s %d\x00", objectType, len(content))
+	return append([]byte(header), content...), nil
+}
 
-	decompressed, err := io.ReadAll(reader)
+// ReadObject reads a Git object and returns its type and content
+func ReadObject(sha string) (GitObjectType, []byte, error) {
+	objectType, content, err := objectDB.Read(sha)
 	if err != nil {
-		return nil, fmt.Errorf("error reading from zlib reader: %w", err)
+		return "", nil, fmt.Errorf("error reading git object: %w", err)
 	}
-
-	return decompressed, nil
+	return objectType, content, nil
 }
 
 // ParseGitObject parses a Git object and returns its type and content
@@ -142,50 +145,23 @@ func ParsePktLine(data []byte) (string, []byte, error) {
 // ReadPktLines reads all pkt-lines from a response
 func ReadPktLines(resp *http.Response) ([]string, error) {
 	var lines []string
-	buf := make([]byte, 1024)
+	reader := NewPktLineReader(resp.Body)
 
 	for {
-		n, err := resp.Body.Read(buf)
-		if n == 0 {
+		payload, flush, err := reader.ReadPacket()
+		if err == io.EOF {
 			break
 		}
-		if err != nil && err != io.EOF {
+		if err != nil {
 			return nil, err
 		}
 
-		// Process the buffer in pkt-line chunks
-		data := buf[:n]
-		for len(data) > 0 {
-			if len(data) < 4 {
-				break
-			}
-
-			lengthStr := string(data[:4])
-			length, err := strconv.ParseInt(lengthStr, 16, 32)
-			if err != nil {
-				return nil, fmt.Errorf("invalid pkt-line length: %s", lengthStr)
-			}
-
-			if length == 0 {
-				// Flush packet
-				lines = append(lines, "")
-				data = data[4:]
-				continue
-			}
-
-			if int(length) > len(data) {
-				// Not enough data, need to read more
-				break
-			}
-
-			payload := string(data[4:length])
-			lines = append(lines, strings.TrimSpace(payload))
-			data = data[length:]
-		}
-
-		if err == io.EOF {
-			break
+		if flush {
+			// Flush packet
+			lines = append(lines, "")
+			continue
 		}
+		lines = append(lines, strings.TrimSpace(string(payload)))
 	}
 
 	return lines, nil
@@ -285,190 +261,60 @@ const (
 	OBJ_REF_DELTA = 7
 )
 
-// ParsePackfile parses a packfile and extracts objects
-func ParsePackfile(data []byte) error {
-	if len(data) < 12 {
-		return fmt.Errorf("packfile too short")
-	}
-
-	// Check header
-	if string(data[:4]) != "PACK" {
-		return fmt.Errorf("invalid packfile header")
-	}
-
-	// Parse version (should be 2)
-	version := (uint32(data[4]) << 24) | (uint32(data[5]) << 16) | (uint32(data[6]) << 8) | uint32(data[7])
-	if version != 2 {
-		return fmt.Errorf("unsupported packfile version: %d", version)
-	}
+// packedObject is a fully resolved object read from a packfile
+type packedObject struct {
+	objType GitObjectType
+	content []byte
+}
 
-	// Parse object count
-	objectCount := (uint32(data[8]) << 24) | (uint32(data[9]) << 16) | (uint32(data[10]) << 8) | uint32(data[11])
+// PackVerificationError reports a packfile object whose content does not
+// hash to the object name it was expected to have
+type PackVerificationError struct {
+	Offset   int
+	Expected string
+	Actual   string
+}
 
-	fmt.Printf("Parsing packfile with %d objects\n", objectCount)
+func (e *PackVerificationError) Error() string {
+	return fmt.Sprintf("pack verification failed for object at offset %d: expected %s, got %s", e.Offset, e.Expected, e.Actual)
+}
 
-	// If object count is 0, there's nothing to parse
-	if objectCount == 0 {
-		fmt.Printf("No objects to parse in packfile\n")
-		return nil
+// packObjectType maps a packfile object type to its Git object type
+func packObjectType(objType int) (GitObjectType, error) {
+	switch objType {
+	case OBJ_COMMIT:
+		return CommitObject, nil
+	case OBJ_TREE:
+		return TreeObject, nil
+	case OBJ_BLOB:
+		return BlobObject, nil
+	case OBJ_TAG:
+		return TagObject, nil
+	default:
+		return "", fmt.Errorf("unsupported object type: %d", objType)
 	}
+}
 
-	// First pass: collect all base objects
-	baseObjects := make(map[string][]byte)
-
-	// Process objects
-	offset := 12
-	for i := uint32(0); i < objectCount; i++ {
-		if offset >= len(data) {
-			return fmt.Errorf("unexpected end of packfile at object %d", i)
-		}
-
-		// Parse object header
-		objType, _, headerSize := parseObjectHeader(data[offset:])
-		if headerSize == 0 {
-			return fmt.Errorf("invalid object header at object %d", i)
-		}
-
-		offset += headerSize
-
-		if offset >= len(data) {
-			return fmt.Errorf("unexpected end of packfile after header of object %d", i)
-		}
-
-		// Handle different object types
-		switch objType {
-		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
-			// Regular object - decompress with zlib
-			// We need to decompress the data starting from the current offset
-			reader := bytes.NewReader(data[offset:])
-			zlibReader, err := zlib.NewReader(reader)
-			if err != nil {
-				return fmt.Errorf("error creating zlib reader for object %d: %w", i, err)
-			}
-
-			content, err := io.ReadAll(zlibReader)
-			if err != nil {
-				zlibReader.Close()
-				return fmt.Errorf("error reading zlib data for object %d: %w", i, err)
-			}
-			zlibReader.Close()
-
-			// Determine how much data was consumed by checking the reader position
-			consumed := int(reader.Size()) - int(reader.Len())
-
-			// Determine object type
-			var gitObjType GitObjectType
-			switch objType {
-			case OBJ_COMMIT:
-				gitObjType = CommitObject
-			case OBJ_TREE:
-				gitObjType = TreeObject
-			case OBJ_BLOB:
-				gitObjType = BlobObject
-			default:
-				return fmt.Errorf("unsupported object type: %d", objType)
-			}
-
-			// Store the object
-			shaBytes := WriteGitObject(gitObjType, content, true)
-			baseObjects[string(shaBytes)] = content
-
-			// Move offset forward by the amount of data consumed
-			offset += consumed
-
-		case OBJ_REF_DELTA:
-			// REF_DELTA: base object is referenced by its 20-byte SHA-1
-			if offset+20 > len(data) {
-				return fmt.Errorf("not enough data for REF_DELTA base SHA at object %d", i)
-			}
-			baseSHA := hex.EncodeToString(data[offset : offset+20])
-			offset += 20
-
-			// Read the compressed delta data
-			reader := bytes.NewReader(data[offset:])
-			zlibReader, err := zlib.NewReader(reader)
-			if err != nil {
-				return fmt.Errorf("error creating zlib reader for delta object %d: %w", i, err)
-			}
-
-			deltaData, err := io.ReadAll(zlibReader)
-			if err != nil {
-				zlibReader.Close()
-				return fmt.Errorf("error reading delta data for object %d: %w", i, err)
-			}
-			zlibReader.Close()
-
-			consumed := int(reader.Size()) - int(reader.Len())
-			offset += consumed
-
-			// Try to resolve the delta
-			baseData, exists := baseObjects[baseSHA]
-			if !exists {
-				// Try to read from disk
-				baseData, err = ReadGitObject(baseSHA)
-				if err != nil {
-					// Can't resolve, skip this delta for now
-					continue
-				}
-				// Parse to get just the content (strip header)
-				_, baseData, err = ParseGitObject(baseData)
-				if err != nil {
-					continue
-				}
-			}
-
-			// Apply the delta
-			result, err := applyDelta(baseData, deltaData)
-			if err != nil {
-				// Can't apply delta, skip
-				continue
-			}
-
-			// Determine object type by trying to infer from base object or content
-			// For simplicity, try all types and use the one that matches baseSHA pattern
-			gitObjType := inferObjectType(result)
-			shaBytes := WriteGitObject(gitObjType, result, true)
-			baseObjects[string(shaBytes)] = result
-
-		case OBJ_OFS_DELTA:
-			// OFS_DELTA: base object is at a negative offset from current position
-			// Parse the offset
-			negOffset, offsetBytes := parseOffset(data[offset:])
-			offset += offsetBytes
-
-			// Read the compressed delta data
-			reader := bytes.NewReader(data[offset:])
-			zlibReader, err := zlib.NewReader(reader)
-			if err != nil {
-				return fmt.Errorf("error creating zlib reader for OFS_DELTA %d: %w", i, err)
-			}
-
-			_, err = io.ReadAll(zlibReader)
-			if err != nil {
-				zlibReader.Close()
-				return fmt.Errorf("error reading OFS_DELTA data for object %d: %w", i, err)
-			}
-			zlibReader.Close()
-
-			consumed := int(reader.Size()) - int(reader.Len())
-			offset += consumed
-
-			// Note: Resolving OFS_DELTA requires tracking object positions
-			// For now, we'll skip these as they're more complex
-			_ = negOffset
-
-		default:
-			return fmt.Errorf("unknown object type: %d", objType)
-		}
-
-		if offset >= len(data) {
-			if i < objectCount-1 {
-				return fmt.Errorf("unexpected end of packfile after object %d of %d", i+1, objectCount)
-			}
-			break
-		}
+// packTypeOf maps a Git object type to its packfile object type
+func packTypeOf(objectType GitObjectType) int {
+	switch objectType {
+	case CommitObject:
+		return OBJ_COMMIT
+	case TreeObject:
+		return OBJ_TREE
+	case TagObject:
+		return OBJ_TAG
+	default:
+		return OBJ_BLOB
 	}
+}
 
+// verifyObjectHash checks that an object hashes to the expected SHA-1
+func verifyObjectHash(expected string, objectType GitObjectType, content []byte, offset int) error {
+	actual := HashGitObject(objectType, content)
+	if actual != expected {
+		return &PackVerificationError{Offset: offset, Expected: expected, Actual: actual}
+	}
 	return nil
 }
 
@@ -521,6 +367,20 @@ func parseOffset(data []byte) (int64, int) {
 	return offset, bytes
 }
 
+// encodeOffset encodes the negative offset of an OFS_DELTA base; it is
+// the inverse of parseOffset
+func encodeOffset(offset int64) []byte {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(offset & 0x7f)
+	for offset >>= 7; offset > 0; offset >>= 7 {
+		offset--
+		pos--
+		buf[pos] = 0x80 | byte(offset&0x7f)
+	}
+	return buf[pos:]
+}
+
 // applyDelta applies a delta to a base object
 func applyDelta(base, delta []byte) ([]byte, error) {
 	if len(delta) < 2 {
@@ -534,7 +394,7 @@ func applyDelta(base, delta []byte) ([]byte, error) {
 	srcSize, n := readDeltaSize(delta[offset:])
 	offset += n
 	if srcSize != int64(len(base)) {
-		// Source size doesn't match, but we'll proceed anyway
+		return nil, fmt.Errorf("delta base size mismatch: delta expects %d bytes, base has %d", srcSize, len(base))
 	}
 
 	// Read target size
@@ -552,6 +412,11 @@ func applyDelta(base, delta []byte) ([]byte, error) {
 			// Copy instruction
 			var cpOffset, cpSize int64
 
+			// Each of the low 7 bits announces one argument byte
+			if offset+bits.OnesCount8(cmd&0x7f) > len(delta) {
+				return nil, fmt.Errorf("truncated delta copy instruction")
+			}
+
 			// Read copy offset
 			if (cmd & 0x01) != 0 {
 				cpOffset = int64(delta[offset])
@@ -605,6 +470,14 @@ func applyDelta(base, delta []byte) ([]byte, error) {
 		} else {
 			return nil, fmt.Errorf("invalid delta instruction: 0")
 		}
+
+		if int64(len(result)) > tgtSize {
+			return nil, fmt.Errorf("delta result exceeds declared size %d", tgtSize)
+		}
+	}
+
+	if int64(len(result)) != tgtSize {
+		return nil, fmt.Errorf("delta result size mismatch: declared %d, got %d", tgtSize, len(result))
 	}
 
 	return result, nil
@@ -632,25 +505,6 @@ func readDeltaSize(data []byte) (int64, int) {
 	return size, offset
 }
 
-// inferObjectType tries to infer the Git object type from content
-func inferObjectType(content []byte) GitObjectType {
-	// Try to infer based on content patterns
-	contentStr := string(content)
-
-	// Check for commit pattern
-	if strings.HasPrefix(contentStr, "tree ") {
-		return CommitObject
-	}
-
-	// Check for tree pattern (contains mode and null bytes)
-	if bytes.Contains(content, []byte("100644 ")) || bytes.Contains(content, []byte("100755 ")) || bytes.Contains(content, []byte("40000 ")) {
-		return TreeObject
-	}
-
-	// Default to blob
-	return BlobObject
-}
-
 // parseObjectHeader parses the variable-length header of a packfile object
 func parseObjectHeader(data []byte) (int, int, int) {
 	if len(data) == 0 {
@@ -685,8 +539,24 @@ func parseObjectHeader(data []byte) (int, int, int) {
 	return int(objType), size, offset
 }
 
-// FetchPackfile fetches a packfile from a remote repository
-func FetchPackfile(repoURL, wantSHA string) ([]byte, error) {
+// encodeObjectHeader encodes the variable-length header of a packfile
+// object; it is the inverse of parseObjectHeader
+func encodeObjectHeader(objType int, size int) []byte {
+	b := byte(objType<<4) | byte(size&0xF)
+	size >>= 4
+
+	var header []byte
+	for size > 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7F)
+		size >>= 7
+	}
+	return append(header, b)
+}
+
+// FetchPackfile requests a packfile from a remote repository and returns
+// a stream of the raw pack data
+func FetchPackfile(repoURL, wantSHA string) (io.ReadCloser, error) {
 	// Ensure the URL ends with .git for HTTP Git requests
 	if !strings.HasSuffix(repoURL, ".git") {
 		repoURL += ".git"
@@ -702,9 +572,6 @@ func FetchPackfile(repoURL, wantSHA string) ([]byte, error) {
 
 	// Create HTTP request
 	req, err := http.NewRequest("POST", url, strings.NewReader(body))
-	fmt.Printf("Sending request to %s\n", url)
-	fmt.Printf("Request body: %q\n", body)
-
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -719,83 +586,18 @@ func FetchPackfile(repoURL, wantSHA string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error fetching packfile: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
+		resp.Body.Close()
 		return nil, fmt.Errorf("error fetching packfile: HTTP %d", resp.StatusCode)
 	}
 
-	// Read the raw response body first
-	rawData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-	fmt.Printf("Raw response size: %d bytes\n", len(rawData))
-	if len(rawData) > 20 {
-		fmt.Printf("First 20 bytes: %q\n", string(rawData[:20]))
-	}
-
-	// Try to unwrap the response body (handle side-band encoding)
-	data, err := unwrapSideBand(bytes.NewReader(rawData))
-	if err != nil {
-		return nil, fmt.Errorf("error unwrapping side-band: %w", err)
-	}
-	fmt.Printf("Unwrapped data size: %d bytes\n", len(data))
-	if len(data) > 20 {
-		fmt.Printf("First 20 bytes of unwrapped data: %q\n", string(data[:20]))
-	}
-
-	return data, nil
+	// Demultiplex the side-band stream as it arrives; the caller closes the body
+	return readCloser{Reader: newSideBandReader(resp.Body), Closer: resp.Body}, nil
 }
 
-// unwrapSideBand unwraps side-band encoded data
-func unwrapSideBand(reader io.Reader) ([]byte, error) {
-	var result []byte
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	// Process the data in pkt-line chunks
-	for len(data) > 0 {
-		if len(data) < 4 {
-			// Not enough data for a pkt-line header
-			break
-		}
-
-		lengthStr := string(data[:4])
-		length, err := strconv.ParseInt(lengthStr, 16, 32)
-		if err != nil {
-			// Not a pkt-line, treat as raw data (this shouldn't happen in a proper Git response)
-			return nil, fmt.Errorf("invalid pkt-line header: %q", lengthStr)
-		}
-
-		if length == 0 {
-			// Flush packet
-			data = data[4:]
-			continue
-		}
-
-		if int(length) > len(data) {
-			// Not enough data
-			return nil, fmt.Errorf("incomplete pkt-line: expected %d bytes, got %d", length, len(data))
-		}
-
-		// Extract payload (skip the first byte which is the side-band channel)
-		if length >= 5 { // Need at least 4 bytes for header + 1 byte for channel
-			channel := data[4]
-			payload := data[5:length]
-
-			// Channel 1 is packfile data
-			if channel == 1 {
-				result = append(result, payload...)
-			}
-			// Channel 2 is progress messages (we can ignore)
-			// Channel 3 is error messages (we should handle)
-		}
-
-		data = data[length:]
-	}
-
-	return result, nil
+// readCloser pairs a reader with the closer of the stream underneath it
+type readCloser struct {
+	io.Reader
+	io.Closer
 }
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isNestedRepository reports whether dir is the worktree of another
+// repository, which the outer repository records as a gitlink
+func isNestedRepository(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// nestedGitDir returns the git directory of a nested repository. A
+// submodule's .git is a file pointing elsewhere: "gitdir: <path>"
+func nestedGitDir(dir string) (string, error) {
+	gitPath := filepath.Join(dir, ".git")
+	info, err := os.Stat(gitPath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return gitPath, nil
+	}
+
+	content, err := os.ReadFile(gitPath)
+	if err != nil {
+		return "", err
+	}
+	gitDir, found := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+	if !found {
+		return "", fmt.Errorf("invalid gitfile format: %s", gitPath)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir, nil
+}
+
+// gitlinkHead returns the commit checked out in a nested repository
+func gitlinkHead(dir string) (string, error) {
+	gitDir, err := nestedGitDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	ref := "HEAD"
+	for depth := 0; depth < 5; depth++ {
+		content, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nestedPackedRef(gitDir, ref, dir)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		value := strings.TrimSpace(string(content))
+		target, isSymbolic := strings.CutPrefix(value, "ref: ")
+		if !isSymbolic {
+			if !isObjectSHA(value) {
+				return "", fmt.Errorf("invalid ref %s in %s", ref, dir)
+			}
+			return value, nil
+		}
+		ref = target
+	}
+	return "", fmt.Errorf("symbolic ref %s nested too deeply in %s", ref, dir)
+}
+
+// nestedPackedRef looks a ref up in the packed-refs of a nested repository
+func nestedPackedRef(gitDir, ref, dir string) (string, error) {
+	file, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			sha, name, found := strings.Cut(scanner.Text(), " ")
+			if found && name == ref && isObjectSHA(sha) {
+				return sha, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("'%s' does not have a commit checked out", dir)
+}
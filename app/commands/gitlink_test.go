@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitlinkHead(t *testing.T) {
+	const commit = "1695c2049d11f434925c01e03315c44b12cb0495"
+	const tag = "cfa1d1b07b817bf1b29b878a838047e46be91243"
+
+	// Each layout maps paths inside the nested worktree to their content
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "loose branch",
+			files: map[string]string{
+				".git/HEAD":            "ref: refs/heads/main\n",
+				".git/refs/heads/main": commit + "\n",
+			},
+			want: commit,
+		},
+		{
+			name:  "detached HEAD",
+			files: map[string]string{".git/HEAD": commit + "\n"},
+			want:  commit,
+		},
+		{
+			name: "gitfile with a relative path",
+			files: map[string]string{
+				".git":                           "gitdir: ../modules/sub\n",
+				"../modules/sub/HEAD":            "ref: refs/heads/main\n",
+				"../modules/sub/refs/heads/main": commit + "\n",
+			},
+			want: commit,
+		},
+		{
+			name: "packed branch among peeled tags",
+			files: map[string]string{
+				".git/HEAD": "ref: refs/heads/main\n",
+				".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted \n" +
+					commit + " refs/heads/main\n" +
+					tag + " refs/tags/v1\n" +
+					"^" + commit + "\n",
+			},
+			want: commit,
+		},
+		{
+			name:    "gitfile without gitdir",
+			files:   map[string]string{".git": "not a gitfile\n"},
+			wantErr: "invalid gitfile format",
+		},
+		{
+			name:    "unborn branch",
+			files:   map[string]string{".git/HEAD": "ref: refs/heads/main\n"},
+			wantErr: "does not have a commit checked out",
+		},
+		{
+			name: "peeled line is not a ref",
+			files: map[string]string{
+				".git/HEAD":        "ref: refs/tags/v1\n",
+				".git/packed-refs": tag + " refs/tags/other\n^" + commit + "\n",
+			},
+			wantErr: "does not have a commit checked out",
+		},
+		{
+			name:    "garbage in HEAD",
+			files:   map[string]string{".git/HEAD": "hello\n"},
+			wantErr: "invalid ref HEAD",
+		},
+		{
+			name: "symbolic ref loop",
+			files: map[string]string{
+				".git/HEAD":         "ref: refs/heads/a\n",
+				".git/refs/heads/a": "ref: refs/heads/b\n",
+				".git/refs/heads/b": "ref: refs/heads/a\n",
+			},
+			wantErr: "nested too deeply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "worktree", "sub")
+			for path, content := range tt.files {
+				path = filepath.Join(dir, filepath.FromSlash(path))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := gitlinkHead(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("gitlinkHead = %q, %v; want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("gitlinkHead: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("gitlinkHead = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identity is the person recorded in the author, committer or tagger
+// header of an object, and when they acted
+type Identity struct {
+	Name  string
+	Email string
+	When  time.Time // its location gives the recorded timezone
+}
+
+// String formats the identity as git records it: "Name <email> <unix time> <+hhmm>"
+func (id *Identity) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", id.Name, id.Email, id.When.Unix(), id.When.Format("-0700"))
+}
+
+// authorIdentity is the author of a new commit
+func authorIdentity(config *Config) (*Identity, error) {
+	return identityFor("author", config)
+}
+
+// committerIdentity is the committer of a new commit, or the tagger of a new tag
+func committerIdentity(config *Config) (*Identity, error) {
+	return identityFor("committer", config)
+}
+
+// identityFor looks up an identity the way git does. The environment
+// (GIT_AUTHOR_NAME, GIT_AUTHOR_EMAIL, GIT_AUTHOR_DATE and their
+// GIT_COMMITTER_* counterparts) wins over author.* or committer.* config,
+// which wins over user.name and user.email. Without any of them, the
+// account name and host name are used. The date defaults to now, in the
+// local timezone
+func identityFor(role string, config *Config) (*Identity, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+	lookup := func(envName, key string) string {
+		if value, set := os.LookupEnv(env + envName); set {
+			return value
+		}
+		if value, set := config.Get(role + "." + key); set {
+			return value
+		}
+		value, _ := config.Get("user." + key)
+		return value
+	}
+
+	id := &Identity{
+		Name:  cleanIdentPart(lookup("NAME", "name")),
+		Email: cleanIdentPart(lookup("EMAIL", "email")),
+		When:  time.Now(),
+	}
+
+	if id.Email == "" {
+		id.Email = cleanIdentPart(os.Getenv("EMAIL"))
+	}
+	if id.Name == "" || id.Email == "" {
+		account, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("unable to auto-detect %s identity: %w", role, err)
+		}
+		if id.Name == "" {
+			id.Name = cleanIdentPart(strings.Split(account.Name, ",")[0])
+		}
+		if id.Name == "" {
+			id.Name = account.Username
+		}
+		if id.Email == "" {
+			host, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("unable to auto-detect email address: %w", err)
+			}
+			id.Email = account.Username + "@" + host
+		}
+	}
+	if id.Name == "" {
+		return nil, fmt.Errorf("empty ident name not allowed; set user.name or %sNAME", env)
+	}
+
+	if date := os.Getenv(env + "DATE"); date != "" {
+		when, err := parseIdentDate(date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sDATE: %w", env, err)
+		}
+		id.When = when
+	}
+
+	return id, nil
+}
+
+// cleanIdentPart drops what would break the "Name <email>" layout:
+// angle brackets, newlines and surrounding whitespace
+func cleanIdentPart(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' {
+			return -1
+		}
+		return r
+	}, value)
+	return strings.TrimSpace(value)
+}
+
+// parseAuthor splits a "Name <email>" override such as --author's value
+func parseAuthor(value string) (name, email string, err error) {
+	name, rest, found := strings.Cut(value, "<")
+	email, trailing, closed := strings.Cut(rest, ">")
+	if !found || !closed || strings.TrimSpace(trailing) != "" || strings.TrimSpace(name) == "" {
+		return "", "", fmt.Errorf("--author '%s' is not 'Name <email>'", value)
+	}
+	return cleanIdentPart(name), cleanIdentPart(email), nil
+}
+
+// Date layouts accepted for GIT_*_DATE and --date. Layouts without a
+// timezone are read as local time
+var identDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700", // RFC 2822
+	"Mon Jan 2 15:04:05 2006 -0700",  // git's default log format
+	"2006-01-02T15:04:05Z07:00",      // ISO 8601 strict
+	"2006-01-02 15:04:05 -0700",      // ISO 8601 like
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseIdentDate parses a date in one of the formats git accepts for
+// commits: its internal "<unix time> <+hhmm>" (optionally prefixed with
+// "@"), RFC 2822 or ISO 8601, and "now"
+func parseIdentDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return time.Now(), nil
+	}
+
+	raw := strings.TrimPrefix(value, "@")
+	timestampText, zoneText, hasZone := strings.Cut(raw, " ")
+	if timestamp, err := strconv.ParseInt(timestampText, 10, 64); err == nil {
+		when := time.Unix(timestamp, 0)
+		if !hasZone {
+			return when, nil
+		}
+		location, err := parseTimezone(zoneText)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return when.In(location), nil
+	}
+
+	for _, layout := range identDateLayouts {
+		if when, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+}
+
+// parseTimezone parses a "+hhmm" or "-hhmm" offset
+func parseTimezone(value string) (*time.Location, error) {
+	if len(value) != 5 || (value[0] != '+' && value[0] != '-') {
+		return nil, fmt.Errorf("invalid timezone: %s", value)
+	}
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %s", value)
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil || minutes >= 60 {
+		return nil, fmt.Errorf("invalid timezone: %s", value)
+	}
+
+	offset := hours*3600 + minutes*60
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
@@ -0,0 +1,378 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignorePattern is one line of a .gitignore style file
+type ignorePattern struct {
+	pattern  string // glob, without "!", a leading "/" or a trailing "/"
+	negate   bool   // "!pattern" re-includes what an earlier pattern excluded
+	dirOnly  bool   // "pattern/" only matches directories
+	anchored bool   // a slash other than a trailing one ties the pattern to its directory
+
+	source string // file the pattern was read from
+	line   int
+	text   string // the line as written
+}
+
+// IgnoreMatcher decides which worktree paths are ignored. Patterns come
+// from core.excludesFile, .git/info/exclude and the .gitignore of every
+// directory, in increasing order of precedence
+type IgnoreMatcher struct {
+	global [][]*ignorePattern          // info/exclude first, then core.excludesFile
+	perDir map[string][]*ignorePattern // .gitignore patterns by directory, loaded lazily
+}
+
+// NewIgnoreMatcher loads the repository-wide exclude files
+func NewIgnoreMatcher() (*IgnoreMatcher, error) {
+	m := &IgnoreMatcher{perDir: make(map[string][]*ignorePattern)}
+
+	exclude, err := readIgnoreFile(filepath.Join(".git", "info", "exclude"))
+	if err != nil {
+		return nil, err
+	}
+	m.global = append(m.global, exclude)
+
+	excludesFile, set := loadConfigOrWarn().Get("core.excludesFile")
+	if set {
+		excludesFile = expandHome(excludesFile)
+	} else if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		excludesFile = filepath.Join(xdg, "git", "ignore")
+	} else if home, err := os.UserHomeDir(); err == nil {
+		excludesFile = filepath.Join(home, ".config", "git", "ignore")
+	}
+	if excludesFile != "" {
+		patterns, err := readIgnoreFile(excludesFile)
+		if err != nil {
+			return nil, err
+		}
+		m.global = append(m.global, patterns)
+	}
+
+	return m, nil
+}
+
+// readIgnoreFile parses a .gitignore style file. A missing file has no patterns
+func readIgnoreFile(filePath string) ([]*ignorePattern, error) {
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	var patterns []*ignorePattern
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if pattern := parseIgnorePattern(scanner.Text()); pattern != nil {
+			pattern.source = filePath
+			pattern.line = line
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	return patterns, nil
+}
+
+// parseIgnorePattern parses one line, returning nil for blanks and comments
+func parseIgnorePattern(line string) *ignorePattern {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are dropped unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+
+	p := &ignorePattern{text: line}
+	text := line
+	if text[0] == '!' {
+		p.negate = true
+		text = text[1:]
+	} else if strings.HasPrefix(text, "\\!") || strings.HasPrefix(text, "\\#") {
+		text = text[1:]
+	}
+
+	if strings.HasSuffix(text, "/") {
+		p.dirOnly = true
+		text = strings.TrimRight(text, "/")
+	}
+	if strings.Contains(text, "/") {
+		p.anchored = true
+		text = strings.TrimPrefix(text, "/")
+	}
+	if text == "" {
+		return nil
+	}
+
+	p.pattern = text
+	return p
+}
+
+// matches reports whether the pattern matches a path below its base
+func (p *ignorePattern) matches(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		return wildmatch(p.pattern, relPath)
+	}
+	return wildmatch(p.pattern, path.Base(relPath))
+}
+
+// patternsFor returns the .gitignore patterns of a directory
+func (m *IgnoreMatcher) patternsFor(dir string) []*ignorePattern {
+	if patterns, loaded := m.perDir[dir]; loaded {
+		return patterns
+	}
+
+	file := ".gitignore"
+	if dir != "" {
+		file = dir + "/.gitignore"
+	}
+	patterns, err := readIgnoreFile(filepath.FromSlash(file))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	m.perDir[dir] = patterns
+	return patterns
+}
+
+// matchPath returns the pattern deciding whether the path itself is
+// ignored, or nil if none matches. Parent directories are not considered
+func (m *IgnoreMatcher) matchPath(relPath string, isDir bool) *ignorePattern {
+	// The deepest .gitignore takes precedence, and within a file the last
+	// matching line wins
+	var dirs []string
+	for dir := path.Dir(relPath); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dirs = append(dirs, "")
+			break
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		patterns := m.patternsFor(dir)
+		rel := relPath
+		if dir != "" {
+			rel = strings.TrimPrefix(relPath, dir+"/")
+		}
+		for i := len(patterns) - 1; i >= 0; i-- {
+			if patterns[i].matches(rel, isDir) {
+				return patterns[i]
+			}
+		}
+	}
+
+	for _, patterns := range m.global {
+		for i := len(patterns) - 1; i >= 0; i-- {
+			if patterns[i].matches(relPath, isDir) {
+				return patterns[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Match returns the pattern that decides whether the path is ignored, or
+// nil. A path inside an ignored directory is ignored by that directory's
+// pattern, whatever patterns below it say
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) *ignorePattern {
+	for i := 0; i < len(relPath); i++ {
+		if relPath[i] != '/' {
+			continue
+		}
+		if pattern := m.matchPath(relPath[:i], true); pattern != nil && !pattern.negate {
+			return pattern
+		}
+	}
+	return m.matchPath(relPath, isDir)
+}
+
+// Ignored reports whether a slash separated path relative to the top of
+// the worktree is ignored
+func (m *IgnoreMatcher) Ignored(relPath string, isDir bool) bool {
+	pattern := m.Match(relPath, isDir)
+	return pattern != nil && !pattern.negate
+}
+
+// wildmatch matches a path against a gitignore glob: "*" and "?" do not
+// match "/", "**" between slashes matches any number of directories, and
+// "[...]" is a character class
+func wildmatch(pattern, name string) bool {
+	return wildmatchAt(pattern, 0, name)
+}
+
+// wildmatchAt matches name against pattern[pi:]. The whole pattern is
+// needed to tell whether a "**" starts a path component
+func wildmatchAt(pattern string, pi int, name string) bool {
+	for pi < len(pattern) {
+		switch pattern[pi] {
+		case '*':
+			end := pi
+			for end < len(pattern) && pattern[end] == '*' {
+				end++
+			}
+			doubleStar := end-pi >= 2 && (pi == 0 || pattern[pi-1] == '/') && (end == len(pattern) || pattern[end] == '/')
+
+			if doubleStar {
+				// A trailing "/**" matches everything inside
+				if end == len(pattern) {
+					return true
+				}
+				// "**/" matches zero or more directories
+				if wildmatchAt(pattern, end+1, name) {
+					return true
+				}
+				for i := 0; i < len(name); i++ {
+					if name[i] == '/' && wildmatchAt(pattern, end+1, name[i+1:]) {
+						return true
+					}
+				}
+				return false
+			}
+
+			for i := 0; i <= len(name); i++ {
+				if wildmatchAt(pattern, end, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' {
+					return false
+				}
+			}
+			return false
+
+		case '?':
+			if name == "" || name[0] == '/' {
+				return false
+			}
+			pi, name = pi+1, name[1:]
+
+		case '[':
+			if name == "" || name[0] == '/' {
+				return false
+			}
+			matched, length, ok := matchCharClass(pattern[pi:], name[0])
+			if !ok {
+				// An unterminated class matches a literal "["
+				if name[0] != '[' {
+					return false
+				}
+				pi, name = pi+1, name[1:]
+				continue
+			}
+			if !matched {
+				return false
+			}
+			pi, name = pi+length, name[1:]
+
+		default:
+			if pattern[pi] == '\\' && pi+1 < len(pattern) {
+				pi++
+			}
+			if name == "" || name[0] != pattern[pi] {
+				return false
+			}
+			pi, name = pi+1, name[1:]
+		}
+	}
+	return name == ""
+}
+
+// matchCharClass matches c against the class at the start of pattern and
+// returns the length of the class. ok is false for an unterminated class
+func matchCharClass(pattern string, c byte) (matched bool, length int, ok bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+
+	first := true
+	for i < len(pattern) && (first || pattern[i] != ']') {
+		first = false
+
+		if pattern[i] == '[' && strings.HasPrefix(pattern[i:], "[:") {
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				if matchPosixClass(pattern[i+2:i+2+end], c) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			if hi == '\\' && i+3 < len(pattern) {
+				i++
+				hi = pattern[i+2]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+
+	if i >= len(pattern) {
+		return false, 0, false
+	}
+	return matched != negate, i + 1, true
+}
+
+// matchPosixClass matches the [:name:] classes gitignore supports
+func matchPosixClass(class string, c byte) bool {
+	switch class {
+	case "alnum":
+		return isAlpha(c) || isDigit(c)
+	case "alpha":
+		return isAlpha(c)
+	case "digit":
+		return isDigit(c)
+	case "lower":
+		return 'a' <= c && c <= 'z'
+	case "upper":
+		return 'A' <= c && c <= 'Z'
+	case "space":
+		return c == ' ' || ('\t' <= c && c <= '\r')
+	case "blank":
+		return c == ' ' || c == '\t'
+	case "punct":
+		return c > ' ' && c < 0x7f && !isAlpha(c) && !isDigit(c)
+	case "xdigit":
+		return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+	}
+	return false
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,503 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexPath is the staging area of the repository in the working directory
+var indexPath = filepath.Join(".git", "index")
+
+// Index entry flags
+const (
+	indexFlagAssumeValid = 0x8000
+	indexFlagExtended    = 0x4000
+	indexFlagStageMask   = 0x3000
+	indexFlagStageShift  = 12
+	indexFlagNameMask    = 0x0fff
+
+	// Extended flags, version 3 and later
+	indexFlagSkipWorktree = 0x4000
+	indexFlagIntentToAdd  = 0x2000
+)
+
+// Size of an index entry up to and including its flags
+const indexEntryFixedSize = 62
+
+// IndexEntry is one file of the staging area. Stat data is what lets a
+// later lstat tell whether the file may have changed since it was staged
+type IndexEntry struct {
+	CTimeSec, CTimeNsec uint32
+	MTimeSec, MTimeNsec uint32
+	Dev, Ino            uint32
+	Mode                uint32
+	UID, GID            uint32
+	Size                uint32
+	SHA                 [20]byte
+	Flags               uint16 // assume-valid, stage; the name length is derived from Path
+	ExtendedFlags       uint16 // skip-worktree, intent-to-add
+	Path                string // slash separated, relative to the top of the worktree
+}
+
+// Stage is 0 for a normal entry and 1-3 (base, ours, theirs) for a conflict
+func (e *IndexEntry) Stage() int {
+	return int(e.Flags&indexFlagStageMask) >> indexFlagStageShift
+}
+
+// Index is the parsed content of .git/index
+type Index struct {
+	Version     uint32
+	Entries     []*IndexEntry // sorted by path, then stage
+	Tree        *CacheTree    // TREE extension, nil when there is none
+	ResolveUndo []*ResolveUndoEntry
+
+	// Optional extensions this implementation does not understand,
+	// written back unchanged
+	extensions []indexExtension
+
+	// When the index file was last written. A file modified in the same
+	// instant may have changed after it was staged without its stat data
+	// showing it
+	modTime time.Time
+}
+
+// NewIndex returns an empty index, version 2 unless GIT_INDEX_VERSION says otherwise
+func NewIndex() *Index {
+	version := uint32(2)
+	if value, err := strconv.Atoi(os.Getenv("GIT_INDEX_VERSION")); err == nil && value >= 2 && value <= 4 {
+		version = uint32(value)
+	}
+	return &Index{Version: version}
+}
+
+// ReadIndex reads an index file. A missing file is an empty index
+func ReadIndex(path string) (*Index, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewIndex(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+
+	index, err := DecodeIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index %s: %w", path, err)
+	}
+	index.modTime = info.ModTime()
+	return index, nil
+}
+
+// DecodeIndex parses the DIRC format, versions 2 to 4
+func DecodeIndex(data []byte) (*Index, error) {
+	/*
+		Index format:
+		"DIRC" <version:4> <entry count:4>
+		entries, sorted by path
+		extensions
+		<SHA-1 of everything above:20>
+	*/
+	if len(data) < 12+20 {
+		return nil, fmt.Errorf("index file too short")
+	}
+	if string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("bad index signature %q", data[:4])
+	}
+
+	// An all-zero checksum means the writer skipped hashing (index.skipHash)
+	body := data[:len(data)-20]
+	var checksum [20]byte
+	copy(checksum[:], data[len(data)-20:])
+	if checksum != ([20]byte{}) && checksum != sha1.Sum(body) {
+		return nil, fmt.Errorf("index file corrupt: bad checksum")
+	}
+
+	index := &Index{Version: binary.BigEndian.Uint32(data[4:8])}
+	if index.Version < 2 || index.Version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", index.Version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	pos := 12
+	previousPath := ""
+	for i := uint32(0); i < count; i++ {
+		entry, n, err := decodeIndexEntry(body[pos:], index.Version, previousPath)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		index.Entries = append(index.Entries, entry)
+		previousPath = entry.Path
+		pos += n
+	}
+
+	if err := index.decodeExtensions(body[pos:]); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// decodeIndexEntry parses one entry and returns it with its encoded size
+func decodeIndexEntry(data []byte, version uint32, previousPath string) (*IndexEntry, int, error) {
+	if len(data) < indexEntryFixedSize {
+		return nil, 0, fmt.Errorf("truncated entry")
+	}
+
+	field := func(i int) uint32 {
+		return binary.BigEndian.Uint32(data[i*4:])
+	}
+	entry := &IndexEntry{
+		CTimeSec: field(0), CTimeNsec: field(1),
+		MTimeSec: field(2), MTimeNsec: field(3),
+		Dev: field(4), Ino: field(5),
+		Mode: field(6),
+		UID:  field(7), GID: field(8),
+		Size: field(9),
+	}
+	copy(entry.SHA[:], data[40:60])
+	entry.Flags = binary.BigEndian.Uint16(data[60:62])
+	pos := indexEntryFixedSize
+
+	if entry.Flags&indexFlagExtended != 0 {
+		if version < 3 {
+			return nil, 0, fmt.Errorf("extended flags in a version %d index", version)
+		}
+		if len(data) < pos+2 {
+			return nil, 0, fmt.Errorf("truncated entry")
+		}
+		entry.ExtendedFlags = binary.BigEndian.Uint16(data[pos:])
+		pos += 2
+	}
+
+	if version == 4 {
+		// The path is stored as the number of bytes to drop from the end
+		// of the previous path, followed by the new suffix
+		strip, n := parseOffset(data[pos:])
+		if n == 0 || strip < 0 || int(strip) > len(previousPath) {
+			return nil, 0, fmt.Errorf("invalid path prefix length")
+		}
+		pos += n
+		end := bytes.IndexByte(data[pos:], 0)
+		if end == -1 {
+			return nil, 0, fmt.Errorf("unterminated path")
+		}
+		entry.Path = previousPath[:len(previousPath)-int(strip)] + string(data[pos:pos+end])
+		return entry, pos + end + 1, nil
+	}
+
+	end := bytes.IndexByte(data[pos:], 0)
+	if end == -1 {
+		return nil, 0, fmt.Errorf("unterminated path")
+	}
+	if nameLength := int(entry.Flags & indexFlagNameMask); nameLength != indexFlagNameMask && nameLength != end {
+		return nil, 0, fmt.Errorf("path length %d does not match flags %d", end, nameLength)
+	}
+	entry.Path = string(data[pos : pos+end])
+
+	// Entries are padded with 1-8 NULs to a multiple of 8 bytes
+	size := (pos + end + 8) &^ 7
+	if size > len(data) {
+		return nil, 0, fmt.Errorf("truncated entry")
+	}
+	return entry, size, nil
+}
+
+// Encode serializes the index, including its trailing checksum
+func (idx *Index) Encode() []byte {
+	version := idx.Version
+	if version < 3 {
+		for _, entry := range idx.Entries {
+			if entry.ExtendedFlags != 0 {
+				version = 3
+				break
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.Entries)))
+
+	previousPath := ""
+	for _, entry := range idx.Entries {
+		encodeIndexEntry(&buf, entry, version, previousPath)
+		previousPath = entry.Path
+	}
+	idx.encodeExtensions(&buf)
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return buf.Bytes()
+}
+
+// encodeIndexEntry appends one entry in the layout of the given version
+func encodeIndexEntry(buf *bytes.Buffer, entry *IndexEntry, version uint32, previousPath string) {
+	start := buf.Len()
+	for _, value := range []uint32{
+		entry.CTimeSec, entry.CTimeNsec, entry.MTimeSec, entry.MTimeNsec,
+		entry.Dev, entry.Ino, entry.Mode, entry.UID, entry.GID, entry.Size,
+	} {
+		binary.Write(buf, binary.BigEndian, value)
+	}
+	buf.Write(entry.SHA[:])
+
+	flags := entry.Flags &^ (indexFlagExtended | indexFlagNameMask)
+	flags |= uint16(min(len(entry.Path), indexFlagNameMask))
+	if entry.ExtendedFlags != 0 {
+		flags |= indexFlagExtended
+	}
+	binary.Write(buf, binary.BigEndian, flags)
+	if entry.ExtendedFlags != 0 {
+		binary.Write(buf, binary.BigEndian, entry.ExtendedFlags)
+	}
+
+	if version == 4 {
+		common := 0
+		for common < len(previousPath) && common < len(entry.Path) && previousPath[common] == entry.Path[common] {
+			common++
+		}
+		buf.Write(encodeOffset(int64(len(previousPath) - common)))
+		buf.WriteString(entry.Path[common:])
+		buf.WriteByte(0)
+		return
+	}
+
+	buf.WriteString(entry.Path)
+	size := (buf.Len() - start + 8) &^ 7
+	buf.Write(make([]byte, size-(buf.Len()-start)))
+}
+
+// Write stores the index through index.lock, so readers never see a
+// half-written file and concurrent writers fail instead of racing
+func (idx *Index) Write(path string) error {
+	return writeFileLocked(path, idx.Encode())
+}
+
+// compareIndexEntry orders entries by path bytes, then stage
+func compareIndexEntry(path string, stage int, entry *IndexEntry) int {
+	if c := strings.Compare(path, entry.Path); c != 0 {
+		return c
+	}
+	return stage - entry.Stage()
+}
+
+// search returns where an entry with the path and stage is or would be
+func (idx *Index) search(path string, stage int) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return compareIndexEntry(path, stage, idx.Entries[i]) <= 0
+	})
+	return i, i < len(idx.Entries) && compareIndexEntry(path, stage, idx.Entries[i]) == 0
+}
+
+// Find returns the stage 0 entry for the path, or nil
+func (idx *Index) Find(path string) *IndexEntry {
+	if i, found := idx.search(path, 0); found {
+		return idx.Entries[i]
+	}
+	return nil
+}
+
+// Has reports whether the path has any entry, conflicted or not
+func (idx *Index) Has(path string) bool {
+	i, _ := idx.search(path, 0)
+	return i < len(idx.Entries) && idx.Entries[i].Path == path
+}
+
+// HasDirectory reports whether any entry is below the directory
+func (idx *Index) HasDirectory(dir string) bool {
+	i, _ := idx.search(dir+"/", 0)
+	return i < len(idx.Entries) && strings.HasPrefix(idx.Entries[i].Path, dir+"/")
+}
+
+// Add stages an entry, replacing any entry for the same path and any
+// file or directory the path conflicts with. A stage 0 entry resolves a
+// conflict, replacing every stage
+func (idx *Index) Add(entry *IndexEntry) {
+	if entry.Stage() == 0 {
+		idx.Remove(entry.Path)
+	} else {
+		idx.removeStage(entry.Path, 0)
+		idx.removeStage(entry.Path, entry.Stage())
+	}
+	idx.RemoveDirectory(entry.Path)
+
+	// A file at a/b replaces a file at a
+	for dir := filepath.ToSlash(filepath.Dir(entry.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+		idx.Remove(dir)
+	}
+
+	i, _ := idx.search(entry.Path, entry.Stage())
+	idx.Entries = append(idx.Entries, nil)
+	copy(idx.Entries[i+1:], idx.Entries[i:])
+	idx.Entries[i] = entry
+	idx.Tree.invalidate(entry.Path)
+}
+
+// Remove drops every stage of the path and reports whether there was one.
+// Removed conflict stages are remembered so the resolution can be undone
+func (idx *Index) Remove(path string) bool {
+	start, _ := idx.search(path, 0)
+	end := start
+	for end < len(idx.Entries) && idx.Entries[end].Path == path {
+		end++
+	}
+	if end == start {
+		return false
+	}
+
+	idx.recordResolveUndo(idx.Entries[start:end])
+	idx.Entries = append(idx.Entries[:start], idx.Entries[end:]...)
+	idx.Tree.invalidate(path)
+	return true
+}
+
+// removeStage drops one stage of the path
+func (idx *Index) removeStage(path string, stage int) {
+	if i, found := idx.search(path, stage); found {
+		idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+		idx.Tree.invalidate(path)
+	}
+}
+
+// RemoveDirectory drops every entry below the directory and returns their paths
+func (idx *Index) RemoveDirectory(dir string) []string {
+	prefix := dir + "/"
+	start, _ := idx.search(prefix, 0)
+	end := start
+	var removed []string
+	for end < len(idx.Entries) && strings.HasPrefix(idx.Entries[end].Path, prefix) {
+		removed = append(removed, idx.Entries[end].Path)
+		end++
+	}
+	if end == start {
+		return nil
+	}
+
+	idx.recordResolveUndo(idx.Entries[start:end])
+	idx.Entries = append(idx.Entries[:start], idx.Entries[end:]...)
+	idx.Tree.invalidate(dir)
+	return removed
+}
+
+// indexMode returns the mode git records for a worktree entry. The only
+// directories recorded are nested repositories, as gitlinks
+func indexMode(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return 0120000
+	case info.IsDir():
+		return 0160000
+	case info.Mode()&0111 != 0:
+		return 0100755
+	}
+	return 0100644
+}
+
+// hashWorktreeEntry returns the object a worktree entry is recorded as:
+// the blob of a file's content or of a symlink's target, or the commit
+// checked out in a nested repository. With write, blobs are stored
+func hashWorktreeEntry(path string, info os.FileInfo, write bool) ([20]byte, error) {
+	var sha [20]byte
+	var content []byte
+	var err error
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		var target string
+		target, err = os.Readlink(path)
+		content = []byte(target)
+	case info.IsDir():
+		var head string
+		if head, err = gitlinkHead(path); err == nil {
+			hex.Decode(sha[:], []byte(head))
+		}
+		return sha, err
+	default:
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return sha, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	if write {
+		hex.Decode(sha[:], WriteGitObject(BlobObject, content, true))
+	} else {
+		hex.Decode(sha[:], []byte(HashGitObject(BlobObject, content)))
+	}
+	return sha, nil
+}
+
+// NewIndexEntry builds a stage 0 entry for a file with the given blob
+func NewIndexEntry(path string, info os.FileInfo, sha [20]byte) *IndexEntry {
+	entry := &IndexEntry{
+		Mode: indexMode(info),
+		Size: uint32(info.Size()),
+		SHA:  sha,
+		Path: path,
+	}
+	fillStatData(entry, info)
+	return entry
+}
+
+// StatMatches reports whether the file still has the stat data recorded
+// when the entry was staged, so its content need not be hashed again
+func (idx *Index) StatMatches(entry *IndexEntry, info os.FileInfo) bool {
+	current := NewIndexEntry(entry.Path, info, entry.SHA)
+	if current.Mode != entry.Mode || current.Size != entry.Size ||
+		current.MTimeSec != entry.MTimeSec || current.MTimeNsec != entry.MTimeNsec ||
+		current.CTimeSec != entry.CTimeSec || current.CTimeNsec != entry.CTimeNsec ||
+		current.Ino != entry.Ino || current.UID != entry.UID || current.GID != entry.GID {
+		return false
+	}
+
+	// Racy entries were modified no earlier than the index was written
+	if idx.modTime.IsZero() {
+		return true
+	}
+	indexSec, indexNsec := uint32(idx.modTime.Unix()), uint32(idx.modTime.Nanosecond())
+	return entry.MTimeSec < indexSec || (entry.MTimeSec == indexSec && entry.MTimeNsec < indexNsec)
+}
+
+// worktreePath turns a command line path into an index path: slash
+// separated and relative to the top of the worktree, "" for the top itself
+func worktreePath(arg string) (string, error) {
+	path := arg
+	if filepath.IsAbs(path) {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		if path, err = filepath.Rel(workDir, path); err != nil {
+			return "", err
+		}
+	}
+
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == ".." || strings.HasPrefix(path, "../") {
+		return "", fmt.Errorf("'%s' is outside repository", arg)
+	}
+	if path == "." {
+		return "", nil
+	}
+	return path, nil
+}
+
+// matchesPathspec reports whether an index path is the given path or below it
+func matchesPathspec(path, pathspec string) bool {
+	return pathspec == "" || path == pathspec || strings.HasPrefix(path, pathspec+"/")
+}
@@ -0,0 +1,341 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index extension signatures
+const (
+	indexExtensionTree        = "TREE"
+	indexExtensionResolveUndo = "REUC"
+
+	// These describe the layout of the file itself and go stale as soon
+	// as the entries change, so they are never written back
+	indexExtensionEndOfIndex   = "EOIE"
+	indexExtensionEntryOffsets = "IEOT"
+)
+
+// indexExtension is an extension kept as raw bytes
+type indexExtension struct {
+	signature string
+	data      []byte
+}
+
+// decodeExtensions parses the extensions between the entries and the checksum
+func (idx *Index) decodeExtensions(data []byte) error {
+	for len(data) > 0 {
+		// Format: <signature:4> <size:4> <data>
+		if len(data) < 8 {
+			return fmt.Errorf("truncated index extension")
+		}
+		signature := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return fmt.Errorf("truncated %s extension", signature)
+		}
+		content := data[8 : 8+size]
+		data = data[8+size:]
+
+		var err error
+		switch signature {
+		case indexExtensionTree:
+			idx.Tree, err = decodeCacheTree(content)
+		case indexExtensionResolveUndo:
+			idx.ResolveUndo, err = decodeResolveUndo(content)
+		case indexExtensionEndOfIndex, indexExtensionEntryOffsets:
+		default:
+			// Extensions starting with an uppercase letter may be ignored
+			if signature[0] < 'A' || signature[0] > 'Z' {
+				return fmt.Errorf("index uses %s extension, which we do not understand", signature)
+			}
+			idx.extensions = append(idx.extensions, indexExtension{signature: signature, data: content})
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s extension: %w", signature, err)
+		}
+	}
+	return nil
+}
+
+// encodeExtensions appends the extensions the index carries
+func (idx *Index) encodeExtensions(buf *bytes.Buffer) {
+	write := func(signature string, data []byte) {
+		buf.WriteString(signature)
+		binary.Write(buf, binary.BigEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+
+	if idx.Tree != nil {
+		var tree bytes.Buffer
+		idx.Tree.encode(&tree)
+		write(indexExtensionTree, tree.Bytes())
+	}
+	if len(idx.ResolveUndo) > 0 {
+		write(indexExtensionResolveUndo, encodeResolveUndo(idx.ResolveUndo))
+	}
+	for _, extension := range idx.extensions {
+		write(extension.signature, extension.data)
+	}
+}
+
+// CacheTree is a node of the TREE extension: the tree object already
+// written for a directory of the index, so that write-tree only has to
+// rehash the directories that changed since
+type CacheTree struct {
+	Name       string // path component, "" for the top
+	EntryCount int    // index entries covered, -1 once invalidated
+	SHA        [20]byte
+	Subtrees   []*CacheTree
+}
+
+// Valid reports whether SHA still describes the directory
+func (t *CacheTree) Valid() bool {
+	return t != nil && t.EntryCount >= 0
+}
+
+// decodeCacheTree parses the TREE extension
+func decodeCacheTree(data []byte) (*CacheTree, error) {
+	tree, rest, err := decodeCacheTreeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data")
+	}
+	return tree, nil
+}
+
+// decodeCacheTreeNode parses a node and its subtrees, which follow it depth first
+func decodeCacheTreeNode(data []byte) (*CacheTree, []byte, error) {
+	// Format: <path>\0<entry count> <subtree count>\n[<20_byte_sha>]
+	nameEnd := bytes.IndexByte(data, 0)
+	if nameEnd == -1 {
+		return nil, nil, fmt.Errorf("unterminated path")
+	}
+	lineEnd := bytes.IndexByte(data[nameEnd:], '\n')
+	if lineEnd == -1 {
+		return nil, nil, fmt.Errorf("unterminated counts")
+	}
+	lineEnd += nameEnd
+
+	entryText, subtreeText, found := strings.Cut(string(data[nameEnd+1:lineEnd]), " ")
+	entryCount, err := strconv.Atoi(entryText)
+	if !found || err != nil || entryCount < -1 {
+		return nil, nil, fmt.Errorf("invalid entry count %q", entryText)
+	}
+	subtreeCount, err := strconv.Atoi(subtreeText)
+	if err != nil || subtreeCount < 0 {
+		return nil, nil, fmt.Errorf("invalid subtree count %q", subtreeText)
+	}
+
+	tree := &CacheTree{Name: string(data[:nameEnd]), EntryCount: entryCount}
+	rest := data[lineEnd+1:]
+	if entryCount >= 0 {
+		if len(rest) < 20 {
+			return nil, nil, fmt.Errorf("truncated SHA")
+		}
+		copy(tree.SHA[:], rest[:20])
+		rest = rest[20:]
+	}
+
+	for i := 0; i < subtreeCount; i++ {
+		var subtree *CacheTree
+		subtree, rest, err = decodeCacheTreeNode(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		tree.Subtrees = append(tree.Subtrees, subtree)
+	}
+	return tree, rest, nil
+}
+
+// encode appends the node and its subtrees in the TREE extension layout
+func (t *CacheTree) encode(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s\x00%d %d\n", t.Name, t.EntryCount, len(t.Subtrees))
+	if t.EntryCount >= 0 {
+		buf.Write(t.SHA[:])
+	}
+	for _, subtree := range t.Subtrees {
+		subtree.encode(buf)
+	}
+}
+
+// subtree returns the child for a path component, or nil
+func (t *CacheTree) subtree(name string) *CacheTree {
+	if t == nil {
+		return nil
+	}
+	for _, subtree := range t.Subtrees {
+		if subtree.Name == name {
+			return subtree
+		}
+	}
+	return nil
+}
+
+// invalidate marks every directory on the way to path as changed. A
+// subtree at path itself is dropped, as path is no longer that directory
+func (t *CacheTree) invalidate(path string) {
+	if t == nil {
+		return
+	}
+	t.EntryCount = -1
+
+	name, rest, isDir := strings.Cut(path, "/")
+	for i, subtree := range t.Subtrees {
+		if subtree.Name != name {
+			continue
+		}
+		if isDir {
+			subtree.invalidate(rest)
+		} else {
+			t.Subtrees = append(t.Subtrees[:i], t.Subtrees[i+1:]...)
+		}
+		return
+	}
+}
+
+// sortSubtrees orders subtrees the way git keeps them: by name length, then name
+func (t *CacheTree) sortSubtrees() {
+	sort.Slice(t.Subtrees, func(i, j int) bool {
+		a, b := t.Subtrees[i].Name, t.Subtrees[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// ResolveUndoEntry records the conflict stages of a path that was
+// resolved, so the conflict can be recreated
+type ResolveUndoEntry struct {
+	Path  string
+	Modes [3]uint32 // stages 1 to 3, 0 when the stage was absent
+	SHAs  [3][20]byte
+}
+
+// decodeResolveUndo parses the REUC extension
+func decodeResolveUndo(data []byte) ([]*ResolveUndoEntry, error) {
+	var entries []*ResolveUndoEntry
+
+	for len(data) > 0 {
+		// Format: <path>\0 <octal mode>\0 x3, then a SHA for each non-zero mode
+		entry := &ResolveUndoEntry{}
+		for field := 0; field < 4; field++ {
+			end := bytes.IndexByte(data, 0)
+			if end == -1 {
+				return nil, fmt.Errorf("truncated entry")
+			}
+			if field == 0 {
+				entry.Path = string(data[:end])
+			} else {
+				mode, err := strconv.ParseUint(string(data[:end]), 8, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid mode %q for %s", data[:end], entry.Path)
+				}
+				entry.Modes[field-1] = uint32(mode)
+			}
+			data = data[end+1:]
+		}
+
+		for stage, mode := range entry.Modes {
+			if mode == 0 {
+				continue
+			}
+			if len(data) < 20 {
+				return nil, fmt.Errorf("truncated entry for %s", entry.Path)
+			}
+			copy(entry.SHAs[stage][:], data[:20])
+			data = data[20:]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// encodeResolveUndo serializes the REUC extension
+func encodeResolveUndo(entries []*ResolveUndoEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.WriteString(entry.Path)
+		buf.WriteByte(0)
+		for _, mode := range entry.Modes {
+			fmt.Fprintf(&buf, "%o\x00", mode)
+		}
+		for stage, mode := range entry.Modes {
+			if mode != 0 {
+				buf.Write(entry.SHAs[stage][:])
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// recordResolveUndo remembers the conflict stages among entries being removed
+func (idx *Index) recordResolveUndo(entries []*IndexEntry) {
+	var record *ResolveUndoEntry
+	for _, entry := range entries {
+		stage := entry.Stage()
+		if stage == 0 {
+			continue
+		}
+		if record == nil || record.Path != entry.Path {
+			record = idx.resolveUndoEntry(entry.Path)
+			// Stages of an earlier resolution are replaced
+			record.Modes = [3]uint32{}
+			record.SHAs = [3][20]byte{}
+		}
+		record.Modes[stage-1] = entry.Mode
+		record.SHAs[stage-1] = entry.SHA
+	}
+}
+
+// resolveUndoEntry returns the record for path, adding one in path order
+func (idx *Index) resolveUndoEntry(path string) *ResolveUndoEntry {
+	i := sort.Search(len(idx.ResolveUndo), func(i int) bool {
+		return idx.ResolveUndo[i].Path >= path
+	})
+	if i < len(idx.ResolveUndo) && idx.ResolveUndo[i].Path == path {
+		return idx.ResolveUndo[i]
+	}
+
+	entry := &ResolveUndoEntry{Path: path}
+	idx.ResolveUndo = append(idx.ResolveUndo, nil)
+	copy(idx.ResolveUndo[i+1:], idx.ResolveUndo[i:])
+	idx.ResolveUndo[i] = entry
+	return entry
+}
+
+// Unresolve puts the recorded conflict stages of path back in place of
+// its resolution, and reports whether there was a record
+func (idx *Index) Unresolve(path string) bool {
+	i := sort.Search(len(idx.ResolveUndo), func(i int) bool {
+		return idx.ResolveUndo[i].Path >= path
+	})
+	if i == len(idx.ResolveUndo) || idx.ResolveUndo[i].Path != path {
+		return false
+	}
+	record := idx.ResolveUndo[i]
+	idx.ResolveUndo = append(idx.ResolveUndo[:i], idx.ResolveUndo[i+1:]...)
+
+	// Dropping the resolution must not record it as a conflict again
+	idx.removeStage(path, 0)
+	for stage, mode := range record.Modes {
+		if mode == 0 {
+			continue
+		}
+		idx.Add(&IndexEntry{
+			Mode:  mode,
+			SHA:   record.SHAs[stage],
+			Flags: uint16(stage+1) << indexFlagStageShift,
+			Path:  path,
+		})
+	}
+	return true
+}
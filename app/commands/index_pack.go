@@ -0,0 +1,754 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// packStream reads a pack from the network, copying every byte to the
+// pack file and the running SHA-1 while tracking the current offset and
+// the CRC32 of the current entry. It implements io.ByteReader so zlib
+// never reads past the end of an entry
+type packStream struct {
+	r      *bufio.Reader
+	out    io.Writer
+	sum    hash.Hash
+	crc    hash.Hash32
+	offset uint64
+}
+
+func newPackStream(r io.Reader, out io.Writer) *packStream {
+	return &packStream{
+		r:   bufio.NewReaderSize(r, 64<<10),
+		out: out,
+		sum: sha1.New(),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+func (s *packStream) consumed(p []byte) error {
+	s.sum.Write(p)
+	s.crc.Write(p)
+	s.offset += uint64(len(p))
+	_, err := s.out.Write(p)
+	return err
+}
+
+func (s *packStream) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	return b, s.consumed([]byte{b})
+}
+
+func (s *packStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		if werr := s.consumed(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// readVarint reads the bytes of a varint whose continuation is the MSB
+func (s *packStream) readVarint() ([]byte, error) {
+	var buf []byte
+	for {
+		b, err := s.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+		if b&0x80 == 0 {
+			return buf, nil
+		}
+		if len(buf) > 10 {
+			return nil, fmt.Errorf("varint too long at offset %d", s.offset)
+		}
+	}
+}
+
+// indexedEntry is what the indexer knows about one entry of the pack
+type indexedEntry struct {
+	offset     uint64
+	crc32      uint32
+	objType    int
+	baseOffset uint64 // OFS_DELTA only
+	baseSHA    string // REF_DELTA only
+	sha        [20]byte
+	resolved   bool
+	claimed    atomic.Bool
+}
+
+// packIndexer turns a received pack into a .pack and .idx pair
+type packIndexer struct {
+	entries []*indexedEntry
+	pack    *Packfile // random access to the pack written so far
+	threads int       // delta resolution workers, 0 for one per CPU
+	fixThin bool      // complete thin packs from the local object store
+
+	// Bases of a thin pack that came from the local object store
+	externalBases []packedObject
+}
+
+// IndexPack reads a pack from r, writing it under .git/objects/pack as it
+// arrives, verifies the trailing checksum, resolves all deltas with the
+// given number of threads (0 for one per CPU) and writes the matching
+// .idx. It returns the path of the stored pack
+func IndexPack(r io.Reader, threads int) (string, error) {
+	packDir := filepath.Join(".git", "objects", "pack")
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating pack directory: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(packDir, "tmp_pack_")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary pack: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+	}()
+
+	ix := &packIndexer{
+		pack:    &Packfile{Path: tmpPath, file: tmpFile, db: objectDB},
+		threads: threads,
+		fixThin: true,
+	}
+
+	out := bufio.NewWriterSize(tmpFile, 64<<10)
+	checksum, err := ix.readPack(r, out)
+	if err != nil {
+		return "", err
+	}
+	if err := out.Flush(); err != nil {
+		return "", fmt.Errorf("error writing pack: %w", err)
+	}
+	fmt.Printf("Received packfile with %d objects\n", len(ix.entries))
+
+	if err := ix.resolveDeltas(); err != nil {
+		return "", err
+	}
+
+	// A stored pack must be self-contained, so thin packs get their
+	// external bases appended, like git index-pack --fix-thin
+	if len(ix.externalBases) > 0 {
+		if checksum, err = ix.fixThinPack(tmpFile); err != nil {
+			return "", err
+		}
+	}
+
+	return storePack(tmpFile, ix.index(checksum))
+}
+
+// IndexPackFile builds the .idx for a pack that is already on disk and
+// writes it to idxPath, returning the pack checksum. The pack must be
+// self-contained
+func IndexPackFile(packPath, idxPath string, threads int) ([20]byte, error) {
+	var checksum [20]byte
+
+	file, err := os.Open(packPath)
+	if err != nil {
+		return checksum, fmt.Errorf("error opening pack: %w", err)
+	}
+	defer file.Close()
+
+	ix := &packIndexer{pack: &Packfile{Path: packPath, file: file, db: objectDB}, threads: threads}
+	if checksum, err = ix.readPack(file, io.Discard); err != nil {
+		return checksum, err
+	}
+	if err := ix.resolveDeltas(); err != nil {
+		return checksum, err
+	}
+
+	if err := writeFileAtomic(idxPath, ix.index(checksum).Encode(), 0444); err != nil {
+		return checksum, fmt.Errorf("error writing pack index: %w", err)
+	}
+	return checksum, nil
+}
+
+// index returns the .idx contents for the resolved entries
+func (ix *packIndexer) index(checksum [20]byte) *PackIndex {
+	idx := &PackIndex{Entries: make([]PackIndexEntry, len(ix.entries)), PackChecksum: checksum}
+	for i, entry := range ix.entries {
+		idx.Entries[i] = PackIndexEntry{SHA: entry.sha, CRC32: entry.crc32, Offset: entry.offset}
+	}
+	return idx
+}
+
+// readPack consumes the pack stream, recording every entry, and returns
+// the verified trailing checksum
+func (ix *packIndexer) readPack(r io.Reader, out io.Writer) ([20]byte, error) {
+	var checksum [20]byte
+	s := newPackStream(r, out)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(s, header); err != nil {
+		return checksum, fmt.Errorf("error reading pack header: %w", err)
+	}
+	if string(header[:4]) != "PACK" {
+		return checksum, fmt.Errorf("invalid packfile header")
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 {
+		return checksum, fmt.Errorf("unsupported packfile version: %d", version)
+	}
+	objectCount := binary.BigEndian.Uint32(header[8:12])
+
+	ix.entries = make([]*indexedEntry, 0, objectCount)
+	for i := uint32(0); i < objectCount; i++ {
+		entry, err := ix.readEntry(s)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return checksum, fmt.Errorf("pack truncated: header announces %d objects, stream ended in object %d", objectCount, i)
+		}
+		if err != nil {
+			return checksum, fmt.Errorf("error reading object %d at offset %d: %w", i, s.offset, err)
+		}
+		ix.entries = append(ix.entries, entry)
+	}
+
+	// The trailer is the SHA-1 of everything before it
+	copy(checksum[:], s.sum.Sum(nil))
+	var trailer [20]byte
+	if _, err := io.ReadFull(s.r, trailer[:]); err != nil {
+		return checksum, fmt.Errorf("pack truncated: missing trailer after %d objects", objectCount)
+	}
+	if trailer != checksum {
+		return checksum, fmt.Errorf("pack checksum mismatch: trailer %x, computed %x", trailer, checksum)
+	}
+	if _, err := out.Write(trailer[:]); err != nil {
+		return checksum, fmt.Errorf("error writing pack: %w", err)
+	}
+
+	// Anything after the trailer means the object count in the header
+	// was wrong or the stream is corrupt
+	if n, _ := io.Copy(io.Discard, s.r); n > 0 {
+		return checksum, fmt.Errorf("pack has %d bytes of trailing garbage after %d objects", n, objectCount)
+	}
+
+	return checksum, nil
+}
+
+// readEntry reads one entry from the stream. Non-delta objects are hashed
+// as they are inflated; deltas are only recorded and resolved later
+func (ix *packIndexer) readEntry(s *packStream) (*indexedEntry, error) {
+	entry := &indexedEntry{offset: s.offset}
+	s.crc.Reset()
+
+	headerBytes, err := s.readVarint()
+	if err != nil {
+		return nil, fmt.Errorf("error reading object header: %w", err)
+	}
+	objType, size, _ := parseObjectHeader(headerBytes)
+	entry.objType = objType
+
+	var hasher hash.Hash
+	switch objType {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		gitObjType, err := packObjectType(objType)
+		if err != nil {
+			return nil, err
+		}
+		hasher = sha1.New()
+		fmt.Fprintf(hasher, "%s %d\x00", gitObjType, size)
+
+	case OBJ_OFS_DELTA:
+		offsetBytes, err := s.readVarint()
+		if err != nil {
+			return nil, fmt.Errorf("error reading OFS_DELTA offset: %w", err)
+		}
+		negOffset, _ := parseOffset(offsetBytes)
+		if negOffset <= 0 || uint64(negOffset) > entry.offset-12 {
+			return nil, fmt.Errorf("invalid OFS_DELTA base offset at offset %d", entry.offset)
+		}
+		entry.baseOffset = entry.offset - uint64(negOffset)
+
+	case OBJ_REF_DELTA:
+		var baseSHA [20]byte
+		if _, err := io.ReadFull(s, baseSHA[:]); err != nil {
+			return nil, fmt.Errorf("error reading REF_DELTA base: %w", err)
+		}
+		entry.baseSHA = hex.EncodeToString(baseSHA[:])
+
+	default:
+		return nil, fmt.Errorf("unknown object type: %d", objType)
+	}
+
+	zlibReader, err := zlib.NewReader(s)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zlib reader: %w", err)
+	}
+	dst := io.Discard
+	if hasher != nil {
+		dst = hasher
+	}
+	inflated, err := io.Copy(dst, zlibReader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	zlibReader.Close()
+
+	// The header's size is the inflated size of the object or delta data
+	if inflated != int64(size) {
+		return nil, fmt.Errorf("object size mismatch: header says %d bytes, inflated %d", size, inflated)
+	}
+
+	if hasher != nil {
+		copy(entry.sha[:], hasher.Sum(nil))
+		entry.resolved = true
+	}
+	entry.crc32 = s.crc.Sum32()
+	return entry, nil
+}
+
+// deltaChildren maps each base to the deltas that use it
+type deltaChildren struct {
+	byOffset map[uint64][]*indexedEntry
+	bySHA    map[string][]*indexedEntry
+}
+
+// children returns the deltas whose base is the object at offset with sha
+func (c *deltaChildren) children(offset uint64, sha string) []*indexedEntry {
+	byOffset, bySHA := c.byOffset[offset], c.bySHA[sha]
+	children := make([]*indexedEntry, 0, len(byOffset)+len(bySHA))
+	return append(append(children, byOffset...), bySHA...)
+}
+
+// deltaTask is a resolved object whose dependent deltas still need to be
+// applied. Roots read from the pack carry no content until a worker loads it
+type deltaTask struct {
+	offset uint64
+	sha    string
+	base   packedObject
+	root   *indexedEntry
+}
+
+// deltaScheduler hands delta tasks to the worker pool. Tasks are taken
+// newest first, so each worker keeps going down the chain it just
+// resolved and few resolved bases are held in memory at once
+type deltaScheduler struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	tasks  []deltaTask
+	active int
+	err    error
+}
+
+func newDeltaScheduler(roots []deltaTask) *deltaScheduler {
+	s := &deltaScheduler{tasks: roots}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *deltaScheduler) push(task deltaTask) {
+	s.mu.Lock()
+	s.tasks = append(s.tasks, task)
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+// next waits for a task. It returns false once every task is done or a
+// worker has failed
+func (s *deltaScheduler) next() (deltaTask, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.tasks) == 0 && s.active > 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.tasks) == 0 || s.err != nil {
+		return deltaTask{}, false
+	}
+
+	task := s.tasks[len(s.tasks)-1]
+	s.tasks = s.tasks[:len(s.tasks)-1]
+	s.active++
+	return task, true
+}
+
+// done marks a task taken with next as finished
+func (s *deltaScheduler) done(err error) {
+	s.mu.Lock()
+	s.active--
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// resolveDeltas works out the object name of every delta. Each non-delta
+// object is the root of a tree of deltas that depend on it, so the trees
+// are walked from the roots down, which does not depend on the order of
+// entries in the pack. Independent chains are resolved in parallel.
+// REF_DELTA bases that are not in the pack at all (thin packs) are read
+// from the local object store
+func (ix *packIndexer) resolveDeltas() error {
+	deltas := &deltaChildren{byOffset: make(map[uint64][]*indexedEntry), bySHA: make(map[string][]*indexedEntry)}
+	for _, entry := range ix.entries {
+		switch entry.objType {
+		case OBJ_OFS_DELTA:
+			deltas.byOffset[entry.baseOffset] = append(deltas.byOffset[entry.baseOffset], entry)
+		case OBJ_REF_DELTA:
+			deltas.bySHA[entry.baseSHA] = append(deltas.bySHA[entry.baseSHA], entry)
+		}
+	}
+	if len(deltas.byOffset) == 0 && len(deltas.bySHA) == 0 {
+		return nil
+	}
+
+	var roots []deltaTask
+	for _, entry := range ix.entries {
+		if entry.objType == OBJ_OFS_DELTA || entry.objType == OBJ_REF_DELTA {
+			continue
+		}
+		sha := hex.EncodeToString(entry.sha[:])
+		if len(deltas.children(entry.offset, sha)) > 0 {
+			roots = append(roots, deltaTask{offset: entry.offset, sha: sha, root: entry})
+		}
+	}
+	if err := ix.runDeltaWorkers(deltas, roots); err != nil {
+		return err
+	}
+
+	if !ix.fixThin {
+		return ix.unresolvedDeltasError()
+	}
+
+	// Thin packs: bases that only exist in the local object store
+	var externalBases []string
+	for sha, children := range deltas.bySHA {
+		for _, child := range children {
+			if !child.resolved {
+				externalBases = append(externalBases, sha)
+				break
+			}
+		}
+	}
+	sort.Strings(externalBases)
+
+	roots = roots[:0]
+	for _, sha := range externalBases {
+		if !objectDB.Has(sha) {
+			continue
+		}
+		objType, content, err := objectDB.Read(sha)
+		if err != nil {
+			return fmt.Errorf("error reading delta base %s: %w", sha, err)
+		}
+		if err := ix.verifyThinBase(sha, objType, content); err != nil {
+			return err
+		}
+		base := packedObject{objType: objType, content: content}
+		ix.externalBases = append(ix.externalBases, base)
+		roots = append(roots, deltaTask{sha: sha, base: base})
+	}
+	if err := ix.runDeltaWorkers(deltas, roots); err != nil {
+		return err
+	}
+
+	return ix.unresolvedDeltasError()
+}
+
+// runDeltaWorkers resolves everything that depends on the given roots
+// using ix.threads goroutines
+func (ix *packIndexer) runDeltaWorkers(deltas *deltaChildren, roots []deltaTask) error {
+	if len(roots) == 0 {
+		return nil
+	}
+
+	// Roots are pushed in reverse so the first one in the pack goes first
+	tasks := make([]deltaTask, len(roots))
+	for i, root := range roots {
+		tasks[len(roots)-1-i] = root
+	}
+	scheduler := newDeltaScheduler(tasks)
+
+	threads := ix.threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, ok := scheduler.next()
+				if !ok {
+					return
+				}
+				scheduler.done(ix.resolveChildren(deltas, task, scheduler))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return scheduler.err
+}
+
+// verifyThinBase checks that a base taken from the object store is the
+// object the deltas were made against
+func (ix *packIndexer) verifyThinBase(sha string, objType GitObjectType, content []byte) error {
+	for _, child := range ix.entries {
+		if child.baseSHA == sha {
+			return verifyObjectHash(sha, objType, content, int(child.offset))
+		}
+	}
+	return nil
+}
+
+// resolveChildren applies every delta based on the task's object and
+// schedules each result so that the deltas based on it are applied next
+func (ix *packIndexer) resolveChildren(deltas *deltaChildren, task deltaTask, scheduler *deltaScheduler) error {
+	base := task.base
+	if task.root != nil {
+		raw, err := ix.pack.readEntry(task.offset)
+		if err != nil {
+			return err
+		}
+		objType, err := packObjectType(raw.objType)
+		if err != nil {
+			return err
+		}
+		base = packedObject{objType: objType, content: raw.data}
+	}
+
+	for _, child := range deltas.children(task.offset, task.sha) {
+		// The same base can appear twice in a pack; only one of them
+		// gets to resolve each delta
+		if !child.claimed.CompareAndSwap(false, true) {
+			continue
+		}
+
+		raw, err := ix.pack.readEntry(child.offset)
+		if err != nil {
+			return err
+		}
+		result, err := applyDelta(base.content, raw.data)
+		if err != nil {
+			return fmt.Errorf("error applying delta at offset %d: %w", child.offset, err)
+		}
+
+		// A delta always has the same type as its base
+		object := packedObject{objType: base.objType, content: result}
+		childSHA := HashGitObject(object.objType, result)
+		hex.Decode(child.sha[:], []byte(childSHA))
+		child.resolved = true
+
+		scheduler.push(deltaTask{offset: child.offset, sha: childSHA, base: object})
+	}
+	return nil
+}
+
+// fixThinPack appends the external bases of a thin pack as full objects,
+// updates the object count in the header and rewrites the trailer. It
+// returns the new pack checksum
+func (ix *packIndexer) fixThinPack(file *os.File) ([20]byte, error) {
+	var checksum [20]byte
+
+	info, err := file.Stat()
+	if err != nil {
+		return checksum, fmt.Errorf("error reading pack size: %w", err)
+	}
+	end := uint64(info.Size()) - 20
+	if err := file.Truncate(int64(end)); err != nil {
+		return checksum, fmt.Errorf("error removing pack trailer: %w", err)
+	}
+
+	for _, base := range ix.externalBases {
+		var entryData bytes.Buffer
+		entryData.Write(encodeObjectHeader(packTypeOf(base.objType), len(base.content)))
+		zlibWriter := zlib.NewWriter(&entryData)
+		zlibWriter.Write(base.content)
+		zlibWriter.Close()
+
+		entry := &indexedEntry{
+			offset:   end,
+			crc32:    crc32.ChecksumIEEE(entryData.Bytes()),
+			objType:  packTypeOf(base.objType),
+			resolved: true,
+		}
+		hex.Decode(entry.sha[:], []byte(HashGitObject(base.objType, base.content)))
+		ix.entries = append(ix.entries, entry)
+
+		if _, err := file.WriteAt(entryData.Bytes(), int64(end)); err != nil {
+			return checksum, fmt.Errorf("error appending base object: %w", err)
+		}
+		end += uint64(entryData.Len())
+	}
+
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(len(ix.entries)))
+	if _, err := file.WriteAt(count[:], 8); err != nil {
+		return checksum, fmt.Errorf("error updating pack header: %w", err)
+	}
+
+	// The whole pack has to be hashed again for the new trailer
+	sum := sha1.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, int64(end))); err != nil {
+		return checksum, fmt.Errorf("error hashing pack: %w", err)
+	}
+	copy(checksum[:], sum.Sum(nil))
+	if _, err := file.WriteAt(checksum[:], int64(end)); err != nil {
+		return checksum, fmt.Errorf("error writing pack trailer: %w", err)
+	}
+
+	return checksum, nil
+}
+
+// unresolvedDeltasError reports the bases that no delta could be resolved against
+func (ix *packIndexer) unresolvedDeltasError() error {
+	seen := make(map[string]bool)
+	var bases []string
+	unresolved := 0
+	for _, entry := range ix.entries {
+		if entry.resolved {
+			continue
+		}
+		unresolved++
+
+		name := entry.baseSHA
+		if entry.objType == OBJ_OFS_DELTA {
+			name = fmt.Sprintf("offset %d", entry.baseOffset)
+		}
+		if !seen[name] {
+			seen[name] = true
+			bases = append(bases, name)
+		}
+	}
+	if unresolved == 0 {
+		return nil
+	}
+
+	sort.Strings(bases)
+	return fmt.Errorf("%d deltas could not be resolved, missing bases: %s", unresolved, strings.Join(bases, ", "))
+}
+
+// storePack moves a fully received pack to pack-<checksum>.pack and writes
+// its .idx next to it
+func storePack(tmpFile *os.File, idx *PackIndex) (string, error) {
+	if err := tmpFile.Sync(); err != nil {
+		return "", fmt.Errorf("error syncing pack: %w", err)
+	}
+
+	basePath := filepath.Join(filepath.Dir(tmpFile.Name()), "pack-"+hex.EncodeToString(idx.PackChecksum[:]))
+
+	// Packs are named after their content, so an existing pair is identical
+	if _, err := os.Stat(basePath + ".idx"); err == nil {
+		return basePath + ".pack", nil
+	}
+
+	if err := os.Chmod(tmpFile.Name(), 0444); err != nil {
+		return "", fmt.Errorf("error setting pack permissions: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), basePath+".pack"); err != nil {
+		return "", fmt.Errorf("error storing pack: %w", err)
+	}
+
+	// The .idx is written last so readers never see an index without its pack
+	if err := writeFileAtomic(basePath+".idx", idx.Encode(), 0444); err != nil {
+		return "", fmt.Errorf("error writing pack index: %w", err)
+	}
+
+	return basePath + ".pack", nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp_"+filepath.Base(path)+"_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type IndexPackCommand struct{}
+
+func (c *IndexPackCommand) GetName() string {
+	return "index-pack"
+}
+
+func (c *IndexPackCommand) Execute(cmd *Command) error {
+	// Format: index-pack [-o <index-file>] [--threads=<n>] <pack-file>
+	var packPath, idxPath string
+	threads := 0
+
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		switch {
+		case arg == "-o":
+			if i+1 >= len(cmd.Args) {
+				return fmt.Errorf("option -o requires a value")
+			}
+			idxPath = cmd.Args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--threads="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--threads="))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid thread count: %s", arg)
+			}
+			threads = n
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			if packPath != "" {
+				return fmt.Errorf("usage: index-pack [-o <index-file>] [--threads=<n>] <pack-file>")
+			}
+			packPath = arg
+		}
+	}
+
+	if packPath == "" {
+		return fmt.Errorf("usage: index-pack [-o <index-file>] [--threads=<n>] <pack-file>")
+	}
+	if !strings.HasSuffix(packPath, ".pack") {
+		return fmt.Errorf("packfile name '%s' does not end with '.pack'", packPath)
+	}
+	if idxPath == "" {
+		idxPath = strings.TrimSuffix(packPath, ".pack") + ".idx"
+	}
+
+	checksum, err := IndexPackFile(packPath, idxPath, threads)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hex.EncodeToString(checksum[:]))
+	return nil
+}
@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillStatData copies the lstat fields git records into the entry
+func fillStatData(entry *IndexEntry, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fillModTime(entry, info)
+		return
+	}
+
+	entry.CTimeSec, entry.CTimeNsec = uint32(stat.Ctim.Sec), uint32(stat.Ctim.Nsec)
+	entry.MTimeSec, entry.MTimeNsec = uint32(stat.Mtim.Sec), uint32(stat.Mtim.Nsec)
+	entry.Dev, entry.Ino = uint32(stat.Dev), uint32(stat.Ino)
+	entry.UID, entry.GID = stat.Uid, stat.Gid
+}
+
+// fillModTime records the modification time when no other stat data is available
+func fillModTime(entry *IndexEntry, info os.FileInfo) {
+	modTime := info.ModTime()
+	entry.MTimeSec, entry.MTimeNsec = uint32(modTime.Unix()), uint32(modTime.Nanosecond())
+	entry.CTimeSec, entry.CTimeNsec = entry.MTimeSec, entry.MTimeNsec
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package commands
+
+import "os"
+
+// fillStatData copies the stat fields that are portable into the entry
+func fillStatData(entry *IndexEntry, info os.FileInfo) {
+	modTime := info.ModTime()
+	entry.MTimeSec, entry.MTimeNsec = uint32(modTime.Unix()), uint32(modTime.Nanosecond())
+	entry.CTimeSec, entry.CTimeNsec = entry.MTimeSec, entry.MTimeNsec
+}
@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testIndexEntry builds an entry with distinct stat data and a SHA derived
+// from the path
+func testIndexEntry(path string, stage int, mode uint32) *IndexEntry {
+	return &IndexEntry{
+		CTimeSec: 1700000000, CTimeNsec: 123,
+		MTimeSec: 1700000001, MTimeNsec: 456,
+		Dev: 2049, Ino: uint32(len(path)),
+		Mode: mode,
+		UID:  1000, GID: 1000,
+		Size:  uint32(len(path) * 10),
+		SHA:   sha1.Sum([]byte(path)),
+		Flags: uint16(stage) << indexFlagStageShift,
+		Path:  path,
+	}
+}
+
+// rehashIndex replaces the trailing checksum after data was altered
+func rehashIndex(data []byte) []byte {
+	body := append([]byte(nil), data[:len(data)-20]...)
+	checksum := sha1.Sum(body)
+	return append(body, checksum[:]...)
+}
+
+// normalizeIndexEntries clears the flag bits the encoder derives from the
+// rest of the entry, so decoded entries compare equal to the originals
+func normalizeIndexEntries(entries []*IndexEntry) {
+	for _, entry := range entries {
+		entry.Flags &^= indexFlagExtended | indexFlagNameMask
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	longPath := "deep/" + strings.Repeat("x", 5000)
+	withExtendedFlags := testIndexEntry("sparse/file", 0, 0100644)
+	withExtendedFlags.ExtendedFlags = indexFlagSkipWorktree
+
+	tests := []struct {
+		name        string
+		version     uint32
+		wantVersion uint32
+		entries     []*IndexEntry
+		tree        *CacheTree
+		resolveUndo []*ResolveUndoEntry
+	}{
+		{name: "empty", version: 2, wantVersion: 2},
+		{
+			name: "version 2", version: 2, wantVersion: 2,
+			entries: []*IndexEntry{
+				testIndexEntry("README", 0, 0100644),
+				testIndexEntry("bin/run", 0, 0100755),
+				testIndexEntry("link", 0, 0120000),
+				testIndexEntry("sub", 0, 0160000),
+			},
+		},
+		{
+			name: "conflict stages", version: 2, wantVersion: 2,
+			entries: []*IndexEntry{
+				testIndexEntry("a", 1, 0100644),
+				testIndexEntry("a", 2, 0100644),
+				testIndexEntry("a", 3, 0100644),
+				testIndexEntry("b", 0, 0100644),
+			},
+		},
+		{
+			name: "path longer than the flags can hold", version: 2, wantVersion: 2,
+			entries: []*IndexEntry{testIndexEntry(longPath, 0, 0100644)},
+		},
+		{
+			name: "extended flags upgrade version 2", version: 2, wantVersion: 3,
+			entries: []*IndexEntry{testIndexEntry("plain", 0, 0100644), withExtendedFlags},
+		},
+		{
+			name: "version 3", version: 3, wantVersion: 3,
+			entries: []*IndexEntry{withExtendedFlags},
+		},
+		{
+			name: "version 4 prefix compression", version: 4, wantVersion: 4,
+			entries: []*IndexEntry{
+				testIndexEntry("dir/a", 0, 0100644),
+				testIndexEntry("dir/ab", 0, 0100644),
+				testIndexEntry("dir/b/c", 0, 0100644),
+				testIndexEntry("other", 0, 0100644),
+				testIndexEntry(longPath, 0, 0100644),
+			},
+		},
+		{
+			name: "extensions", version: 2, wantVersion: 2,
+			entries: []*IndexEntry{
+				testIndexEntry("dir/a", 0, 0100644),
+				testIndexEntry("top", 0, 0100644),
+			},
+			tree: &CacheTree{EntryCount: 2, SHA: sha1.Sum([]byte("root")), Subtrees: []*CacheTree{
+				{Name: "dir", EntryCount: -1},
+			}},
+			resolveUndo: []*ResolveUndoEntry{{
+				Path:  "top",
+				Modes: [3]uint32{0, 0100644, 0100755},
+				SHAs:  [3][20]byte{{}, sha1.Sum([]byte("ours")), sha1.Sum([]byte("theirs"))},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := &Index{Version: tt.version, Entries: tt.entries, Tree: tt.tree, ResolveUndo: tt.resolveUndo}
+			data := index.Encode()
+
+			decoded, err := DecodeIndex(data)
+			if err != nil {
+				t.Fatalf("DecodeIndex: %v", err)
+			}
+			if decoded.Version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", decoded.Version, tt.wantVersion)
+			}
+			normalizeIndexEntries(decoded.Entries)
+			if !reflect.DeepEqual(decoded.Entries, tt.entries) {
+				t.Errorf("entries differ after a round trip:\ngot  %+v\nwant %+v", decoded.Entries, tt.entries)
+			}
+			if !reflect.DeepEqual(decoded.Tree, tt.tree) {
+				t.Errorf("cache tree = %+v, want %+v", decoded.Tree, tt.tree)
+			}
+			if !reflect.DeepEqual(decoded.ResolveUndo, tt.resolveUndo) {
+				t.Errorf("resolve-undo = %+v, want %+v", decoded.ResolveUndo, tt.resolveUndo)
+			}
+
+			if again := decoded.Encode(); !bytes.Equal(again, data) {
+				t.Errorf("re-encoding changed the index")
+			}
+		})
+	}
+}
+
+func TestIndexVersion4SharesPrefixes(t *testing.T) {
+	index := &Index{Version: 4, Entries: []*IndexEntry{
+		testIndexEntry("dir/file-a", 0, 0100644),
+		testIndexEntry("dir/file-b", 0, 0100644),
+	}}
+	data := index.Encode()
+
+	// The second path drops one byte of the first and adds "b"
+	if !bytes.Contains(data, []byte("\x01b\x00")) {
+		t.Errorf("second path not stored as a suffix of the first")
+	}
+	if bytes.Count(data, []byte("dir/file-")) != 1 {
+		t.Errorf("shared prefix stored more than once")
+	}
+}
+
+func TestDecodeIndexRejectsMalformed(t *testing.T) {
+	valid := (&Index{Version: 2, Entries: []*IndexEntry{testIndexEntry("file", 0, 0100644)}}).Encode()
+	validV4 := (&Index{Version: 4, Entries: []*IndexEntry{
+		testIndexEntry("ab", 0, 0100644),
+		testIndexEntry("ac", 0, 0100644),
+	}}).Encode()
+
+	// flagsOffset is where the flags of the first entry are
+	const flagsOffset = 12 + 60
+
+	tests := []struct {
+		name string
+		data func() []byte
+		want string
+	}{
+		{"too short", func() []byte { return []byte("DIRC") }, "too short"},
+		{"bad signature", func() []byte {
+			data := append([]byte(nil), valid...)
+			copy(data, "DIRX")
+			return rehashIndex(data)
+		}, "bad index signature"},
+		{"bad checksum", func() []byte {
+			data := append([]byte(nil), valid...)
+			data[len(data)-1] ^= 0xff
+			return data
+		}, "bad checksum"},
+		{"unsupported version", func() []byte {
+			data := append([]byte(nil), valid...)
+			binary.BigEndian.PutUint32(data[4:8], 5)
+			return rehashIndex(data)
+		}, "unsupported index version 5"},
+		{"more entries than present", func() []byte {
+			data := append([]byte(nil), valid...)
+			binary.BigEndian.PutUint32(data[8:12], 2)
+			return rehashIndex(data)
+		}, "entry 1"},
+		{"extended flags in version 2", func() []byte {
+			data := append([]byte(nil), valid...)
+			data[flagsOffset] |= indexFlagExtended >> 8
+			return rehashIndex(data)
+		}, "extended flags in a version 2 index"},
+		{"name length mismatch", func() []byte {
+			data := append([]byte(nil), valid...)
+			data[flagsOffset+1]++
+			return rehashIndex(data)
+		}, "does not match flags"},
+		{"version 4 prefix longer than previous path", func() []byte {
+			data := append([]byte(nil), validV4...)
+			// The second entry strips one byte; make it strip nine
+			i := bytes.Index(data, []byte("\x01c\x00"))
+			data[i] = 9
+			return rehashIndex(data)
+		}, "invalid path prefix length"},
+		{"unknown required extension", func() []byte {
+			body := append([]byte(nil), valid[:len(valid)-20]...)
+			body = append(body, "link\x00\x00\x00\x00"...)
+			return rehashIndex(append(body, make([]byte, 20)...))
+		}, "do not understand"},
+		{"truncated extension", func() []byte {
+			body := append([]byte(nil), valid[:len(valid)-20]...)
+			body = append(body, "TREE\x00\x00\x00\x10abc"...)
+			return rehashIndex(append(body, make([]byte, 20)...))
+		}, "truncated TREE extension"},
+		{"malformed cache tree", func() []byte {
+			body := append([]byte(nil), valid[:len(valid)-20]...)
+			body = append(body, "TREE\x00\x00\x00\x05\x00x 0\n"...)
+			return rehashIndex(append(body, make([]byte, 20)...))
+		}, "invalid entry count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeIndex(tt.data())
+			if err == nil {
+				t.Fatalf("DecodeIndex succeeded, want an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
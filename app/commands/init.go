@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type InitCommand struct{}
+
+func (c *InitCommand) GetName() string {
+	return "init"
+}
+
+func (c *InitCommand) Execute(cmd *Command) error {
+	// Format: init
+	if len(cmd.Args) > 0 {
+		return fmt.Errorf("usage: init")
+	}
+	if err := initRepository(); err != nil {
+		return err
+	}
+	fmt.Println("Initialized git directory")
+	return nil
+}
+
+// initRepository creates the .git directory structure. HEAD points at
+// refs/heads/main in a new repository and is left alone in an existing one
+func initRepository() error {
+	dirs := []string{".git", ".git/objects", ".git/refs", ".git/refs/heads", ".git/refs/tags"}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating directory %s: %w", dir, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(refStore.Dir, "HEAD")); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := refStore.WriteSymbolic("HEAD", "refs/heads/main"); err != nil {
+		return fmt.Errorf("error writing HEAD file: %w", err)
+	}
+	return nil
+}
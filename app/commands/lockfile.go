@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// writeFileLocked replaces a file through <path>.lock. The lock is created
+// exclusively, so concurrent writers fail instead of racing, and readers
+// never see a half-written file
+func writeFileLocked(path string, data []byte) error {
+	lockPath := path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("unable to create '%s': file exists; another git process seems to be running", lockPath)
+		}
+		return fmt.Errorf("unable to create '%s': %w", lockPath, err)
+	}
+
+	if _, err := lock.Write(data); err != nil {
+		lock.Close()
+		os.Remove(lockPath)
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := os.Rename(lockPath, path); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
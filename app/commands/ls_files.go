@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type LsFilesCommand struct{}
+
+func (c *LsFilesCommand) GetName() string {
+	return "ls-files"
+}
+
+func (c *LsFilesCommand) Execute(cmd *Command) error {
+	// Format: ls-files [-s | --stage | --resolve-undo] [-z] [--] [<pathspec>...]
+	var stage, resolveUndo bool
+	terminator := "\n"
+	var pathspecs []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			pathspecs = append(pathspecs, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "-s" || arg == "--stage":
+			stage = true
+		case arg == "--resolve-undo":
+			resolveUndo = true
+		case arg == "-z":
+			terminator = "\x00"
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			pathspecs = append(pathspecs, arg)
+		}
+	}
+
+	var paths []string
+	for _, arg := range pathspecs {
+		path, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	if resolveUndo {
+		for _, record := range index.ResolveUndo {
+			if len(paths) > 0 && !matchesAnyPathspec(record.Path, paths) {
+				continue
+			}
+			for stage, mode := range record.Modes {
+				if mode != 0 {
+					fmt.Printf("%06o %s %d\t%s%s", mode, hex.EncodeToString(record.SHAs[stage][:]), stage+1, record.Path, terminator)
+				}
+			}
+		}
+		return nil
+	}
+
+	previous := ""
+	for _, entry := range index.Entries {
+		if len(paths) > 0 && !matchesAnyPathspec(entry.Path, paths) {
+			continue
+		}
+		if stage {
+			fmt.Printf("%06o %s %d\t%s%s", entry.Mode, hex.EncodeToString(entry.SHA[:]), entry.Stage(), entry.Path, terminator)
+		} else if entry.Path != previous {
+			// Conflicted paths are listed once
+			fmt.Printf("%s%s", entry.Path, terminator)
+		}
+		previous = entry.Path
+	}
+	return nil
+}
+
+// matchesAnyPathspec reports whether the path is one of the pathspecs or below one
+func matchesAnyPathspec(path string, pathspecs []string) bool {
+	for _, pathspec := range pathspecs {
+		if matchesPathspec(path, pathspec) {
+			return true
+		}
+	}
+	return false
+}
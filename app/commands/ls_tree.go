@@ -1,9 +1,9 @@
 package commands
 
 import (
-	"bytes"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,35 +13,217 @@ func (c *LsTreeComand) GetName() string {
 	return "ls-tree"
 }
 
-func (c *LsTreeComand) Execute(cmd *Command) {
-	if len(cmd.Args) != 2 {
-		fmt.Println(cmd.Usage)
-		os.Exit(1)
+// lsTreeOptions are the ls-tree flags
+type lsTreeOptions struct {
+	recursive  bool // -r: descend into subtrees
+	showTrees  bool // -t: show trees even when descending into them
+	treesOnly  bool // -d: show only trees
+	long       bool // -l: show blob sizes
+	nameOnly   bool // --name-only
+	terminator string
+	pathspecs  []string
+}
+
+func (c *LsTreeComand) Execute(cmd *Command) error {
+	// Format: ls-tree [-d] [-r] [-t] [-l] [-z] [--name-only] <tree-ish> [<path>...]
+	opts := lsTreeOptions{terminator: "\n"}
+	var positional []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			positional = append(positional, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "-r":
+			opts.recursive = true
+		case arg == "-t":
+			opts.showTrees = true
+		case arg == "-d":
+			opts.treesOnly = true
+		case arg == "-l" || arg == "--long":
+			opts.long = true
+		case arg == "-z":
+			opts.terminator = "\x00"
+		case arg == "--name-only" || arg == "--name-status":
+			opts.nameOnly = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: ls-tree [-d] [-r] [-t] [-l] [-z] [--name-only] <tree-ish> [<path>...]")
 	}
 
-	// Read and decompress the git object
-	data, err := ReadGitObject(cmd.Args[1])
+	treeSHA, err := ResolveTree(positional[0])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading git object: %s\n", err)
-		os.Exit(1)
+		return err
+	}
+	for _, arg := range positional[1:] {
+		path, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+		// A trailing slash asks for what is inside the directory
+		if strings.HasSuffix(arg, "/") && path != "" {
+			path += "/"
+		}
+		opts.pathspecs = append(opts.pathspecs, path)
 	}
 
-	// Parse the tree object
-	_, content, err := ParseGitObject(data)
+	return listTree(treeSHA, "", &opts)
+}
+
+// listTree prints the entries of a tree, descending into subtrees when
+// asked to or when a pathspec lies below them
+func listTree(treeSHA, prefix string, opts *lsTreeOptions) error {
+	tree, err := ReadTree(treeSHA)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing git object: %s\n", err)
-		os.Exit(1)
+		return err
+	}
+
+	for _, entry := range tree.Entries {
+		path := prefix + entry.Name
+		isTree := entry.Mode == "40000"
+
+		matched, below := lsTreeMatch(path, opts.pathspecs)
+		if !matched && !below {
+			continue
+		}
+
+		// Trees that are descended into are only shown with -t or -d, and
+		// trees opened just to reach a pathspec only with -t
+		descend := isTree && (below || (matched && opts.recursive))
+		show := matched && (!isTree || !descend || opts.showTrees || opts.treesOnly)
+		if isTree && below && !matched && opts.showTrees {
+			show = true
+		}
+		if opts.treesOnly && !isTree {
+			show = false
+		}
+
+		if show {
+			if err := printTreeEntry(entry, path, opts); err != nil {
+				return err
+			}
+		}
+		if descend {
+			if err := listTree(hex.EncodeToString(entry.SHA), path+"/", opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lsTreeMatch reports whether a path is selected by the pathspecs, and
+// whether a pathspec lies below it, so that its tree has to be opened
+func lsTreeMatch(path string, pathspecs []string) (matched, below bool) {
+	if len(pathspecs) == 0 {
+		return true, false
+	}
+	for _, pathspec := range pathspecs {
+		dir := strings.TrimSuffix(pathspec, "/")
+		switch {
+		case pathspec == "" || strings.HasPrefix(path, dir+"/"):
+			matched = true
+		case path == dir && dir == pathspec:
+			matched = true
+		case path == dir || strings.HasPrefix(pathspec, path+"/"):
+			below = true
+		}
+	}
+	return matched, below
+}
+
+// printTreeEntry prints one line of ls-tree output
+func printTreeEntry(entry TreeEntry, path string, opts *lsTreeOptions) error {
+	name := path
+	if opts.terminator == "\n" {
+		name = quotePath(path)
+	}
+	if opts.nameOnly {
+		fmt.Printf("%s%s", name, opts.terminator)
+		return nil
+	}
+
+	mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %q for %s", entry.Mode, path)
+	}
+	objectType := BlobObject
+	switch entry.Mode {
+	case "40000":
+		objectType = TreeObject
+	case "160000":
+		objectType = CommitObject
+	}
+	sha := hex.EncodeToString(entry.SHA)
+
+	if !opts.long {
+		fmt.Printf("%06o %s %s\t%s%s", mode, objectType, sha, name, opts.terminator)
+		return nil
+	}
+
+	size := "-"
+	if objectType == BlobObject {
+		_, content, err := ReadObject(sha)
+		if err != nil {
+			return err
+		}
+		size = strconv.Itoa(len(content))
+	}
+	fmt.Printf("%06o %s %s %7s\t%s%s", mode, objectType, sha, size, name, opts.terminator)
+	return nil
+}
+
+// quotePath quotes a path the way git does when it holds a double quote,
+// a backslash, a control character or a non-ASCII byte
+func quotePath(path string) string {
+	needsQuotes := false
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x7f {
+			needsQuotes = true
+			break
+		}
+	}
+	if !needsQuotes {
+		return path
 	}
 
-	// Parse tree entries: <mode> <name>\0<20_byte_sha>
-	var result string
-	lines := bytes.Split(content, []byte("\x00"))
-	for _, line := range lines {
-		parts := bytes.Split(line, []byte(" "))
-		if len(parts) >= 2 {
-			result = fmt.Sprintf("%s\n%s", result, string(parts[len(parts)-1]))
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch c {
+		case '"', '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\v':
+			quoted.WriteString(`\v`)
+		case '\f':
+			quoted.WriteString(`\f`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&quoted, "\\%03o", c)
+			} else {
+				quoted.WriteByte(c)
+			}
 		}
 	}
-	result += "\n"
-	fmt.Printf("%s", strings.TrimPrefix(result, "\n"))
+	quoted.WriteByte('"')
+	return quoted.String()
 }
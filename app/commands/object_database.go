@@ -0,0 +1,247 @@
+package commands
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Deltas deeper than this are treated as corrupt rather than followed
+const maxDeltaDepth = 4095
+
+// Total size of resolved delta bases kept in memory
+const deltaBaseCacheLimit = 96 << 20
+
+// ObjectDatabase reads objects from a .git/objects directory, looking at
+// loose objects first and then at every pack under objects/pack
+type ObjectDatabase struct {
+	Dir string
+
+	mu    sync.Mutex
+	packs map[string]*Packfile
+	cache *deltaBaseCache
+}
+
+// NewObjectDatabase returns a database for the given objects directory
+func NewObjectDatabase(dir string) *ObjectDatabase {
+	return &ObjectDatabase{
+		Dir:   dir,
+		packs: make(map[string]*Packfile),
+		cache: newDeltaBaseCache(deltaBaseCacheLimit),
+	}
+}
+
+// objectDB is the object database of the repository in the working directory
+var objectDB = NewObjectDatabase(filepath.Join(".git", "objects"))
+
+// ObjectNotFoundError is returned when no loose object or pack has the object
+type ObjectNotFoundError struct {
+	SHA string
+}
+
+func (e *ObjectNotFoundError) Error() string {
+	return fmt.Sprintf("object %s not found", e.SHA)
+}
+
+// Read returns the type and content of an object
+func (db *ObjectDatabase) Read(sha string) (GitObjectType, []byte, error) {
+	var raw [20]byte
+	if len(sha) != 40 {
+		return "", nil, fmt.Errorf("invalid object name: %s", sha)
+	}
+	if _, err := hex.Decode(raw[:], []byte(sha)); err != nil {
+		return "", nil, fmt.Errorf("invalid object name: %s", sha)
+	}
+
+	objectType, content, err := db.readLoose(sha)
+	if err == nil || !os.IsNotExist(err) {
+		return objectType, content, err
+	}
+
+	pack, err := db.findPack(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if pack == nil {
+		return "", nil, &ObjectNotFoundError{SHA: sha}
+	}
+	return pack.Read(raw)
+}
+
+// Has reports whether the object exists, loose or packed
+func (db *ObjectDatabase) Has(sha string) bool {
+	var raw [20]byte
+	if len(sha) != 40 {
+		return false
+	}
+	if _, err := hex.Decode(raw[:], []byte(sha)); err != nil {
+		return false
+	}
+	if _, err := os.Stat(db.loosePath(sha)); err == nil {
+		return true
+	}
+	pack, _ := db.findPack(raw)
+	return pack != nil
+}
+
+// loosePath returns .git/objects/<first_2>/<remaining_38>
+func (db *ObjectDatabase) loosePath(sha string) string {
+	return filepath.Join(db.Dir, sha[:2], sha[2:])
+}
+
+// readLoose reads and decompresses a loose object
+func (db *ObjectDatabase) readLoose(sha string) (GitObjectType, []byte, error) {
+	compressed, err := os.ReadFile(db.loosePath(sha))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Decompress using zlib
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating zlib reader: %w", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading from zlib reader: %w", err)
+	}
+
+	return ParseGitObject(decompressed)
+}
+
+// findPack returns the pack holding the object, or nil. The pack directory
+// is rescanned on a miss so packs written after startup are found
+func (db *ObjectDatabase) findPack(sha [20]byte) (*Packfile, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, rescan := range []bool{false, true} {
+		if rescan {
+			if err := db.loadPacks(); err != nil {
+				return nil, err
+			}
+		}
+		for _, pack := range db.sortedPacks() {
+			if pack.Contains(sha) {
+				return pack, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Packs returns every pack in the database, sorted by path
+func (db *ObjectDatabase) Packs() ([]*Packfile, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.loadPacks(); err != nil {
+		return nil, err
+	}
+	return db.sortedPacks(), nil
+}
+
+// loadPacks opens packs that appeared under objects/pack and forgets
+// packs that were removed. Callers hold db.mu
+func (db *ObjectDatabase) loadPacks() error {
+	paths, err := filepath.Glob(filepath.Join(db.Dir, "pack", "*.pack"))
+	if err != nil {
+		return fmt.Errorf("error listing packs: %w", err)
+	}
+
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+		if _, exists := db.packs[path]; exists {
+			continue
+		}
+		// A pack without its .idx is still being written
+		if _, err := os.Stat(path[:len(path)-len(".pack")] + ".idx"); err != nil {
+			continue
+		}
+		pack, err := OpenPackfile(path, db)
+		if err != nil {
+			return err
+		}
+		db.packs[path] = pack
+	}
+
+	for path, pack := range db.packs {
+		if !present[path] {
+			pack.Close()
+			delete(db.packs, path)
+		}
+	}
+	return nil
+}
+
+// sortedPacks returns the loaded packs in a stable order. Callers hold db.mu
+func (db *ObjectDatabase) sortedPacks() []*Packfile {
+	packs := make([]*Packfile, 0, len(db.packs))
+	for _, pack := range db.packs {
+		packs = append(packs, pack)
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].Path < packs[j].Path
+	})
+	return packs
+}
+
+// deltaBaseCache keeps recently resolved pack objects so that long delta
+// chains are not rebuilt from scratch for every object that uses them
+type deltaBaseCache struct {
+	mu      sync.Mutex
+	limit   int
+	size    int
+	entries map[deltaBaseKey]packedObject
+	order   []deltaBaseKey
+}
+
+type deltaBaseKey struct {
+	pack   string
+	offset uint64
+}
+
+func newDeltaBaseCache(limit int) *deltaBaseCache {
+	return &deltaBaseCache{limit: limit, entries: make(map[deltaBaseKey]packedObject)}
+}
+
+func (c *deltaBaseCache) get(pack string, offset uint64) (packedObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	object, exists := c.entries[deltaBaseKey{pack, offset}]
+	return object, exists
+}
+
+// add stores an object, evicting the oldest entries once over the limit
+func (c *deltaBaseCache) add(pack string, offset uint64, object packedObject) {
+	if len(object.content) > c.limit/4 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := deltaBaseKey{pack, offset}
+	if _, exists := c.entries[key]; exists {
+		return
+	}
+	c.entries[key] = object
+	c.order = append(c.order, key)
+	c.size += len(object.content)
+
+	for c.size > c.limit && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.size -= len(c.entries[oldest].content)
+		delete(c.entries, oldest)
+	}
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// packIndexMagic starts every version 2 .idx file
+var packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+// Offsets that do not fit in 31 bits are moved to the 64-bit offset table
+const packIndexLargeOffset = 0x80000000
+
+// PackIndexEntry is one object of a pack as recorded in its .idx
+type PackIndexEntry struct {
+	SHA    [20]byte
+	CRC32  uint32
+	Offset uint64
+}
+
+// PackIndex is a version 2 pack index
+type PackIndex struct {
+	Entries      []PackIndexEntry
+	PackChecksum [20]byte
+}
+
+// Sort orders the entries by SHA-1, as the .idx format requires
+func (idx *PackIndex) Sort() {
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return bytes.Compare(idx.Entries[i].SHA[:], idx.Entries[j].SHA[:]) < 0
+	})
+}
+
+// Encode serializes the index in the version 2 .idx format:
+//
+//	magic, version, 256-entry fanout table, sorted SHA-1s, CRC32s,
+//	4-byte offsets, 8-byte offsets, pack checksum, index checksum
+func (idx *PackIndex) Encode() []byte {
+	idx.Sort()
+
+	var buf bytes.Buffer
+	buf.Write(packIndexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	// Fanout: entry i is the number of objects whose first byte is <= i
+	var fanout [256]uint32
+	for _, entry := range idx.Entries {
+		fanout[entry.SHA[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, entry := range idx.Entries {
+		buf.Write(entry.SHA[:])
+	}
+	for _, entry := range idx.Entries {
+		binary.Write(&buf, binary.BigEndian, entry.CRC32)
+	}
+
+	var largeOffsets []uint64
+	for _, entry := range idx.Entries {
+		if entry.Offset < packIndexLargeOffset {
+			binary.Write(&buf, binary.BigEndian, uint32(entry.Offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(packIndexLargeOffset|len(largeOffsets)))
+		largeOffsets = append(largeOffsets, entry.Offset)
+	}
+	for _, offset := range largeOffsets {
+		binary.Write(&buf, binary.BigEndian, offset)
+	}
+
+	buf.Write(idx.PackChecksum[:])
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	return buf.Bytes()
+}
+
+// DecodePackIndex parses a version 2 .idx file and verifies its checksum
+func DecodePackIndex(data []byte) (*PackIndex, error) {
+	if len(data) < 8+256*4+40 {
+		return nil, fmt.Errorf("pack index too short")
+	}
+	if !bytes.Equal(data[:4], packIndexMagic) {
+		return nil, fmt.Errorf("unsupported pack index format")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, fmt.Errorf("unsupported pack index version: %d", version)
+	}
+
+	checksum := sha1.Sum(data[:len(data)-20])
+	if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+		return nil, fmt.Errorf("pack index checksum mismatch")
+	}
+
+	// The last fanout entry is the total number of objects
+	fanout := data[8 : 8+256*4]
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+
+	shaTable := 8 + 256*4
+	crcTable := shaTable + count*20
+	offsetTable := crcTable + count*4
+	largeOffsetTable := offsetTable + count*4
+	if largeOffsetTable+40 > len(data) {
+		return nil, fmt.Errorf("pack index truncated: %d objects do not fit", count)
+	}
+	largeOffsetCount := (len(data) - 40 - largeOffsetTable) / 8
+
+	idx := &PackIndex{Entries: make([]PackIndexEntry, count)}
+	for i := range idx.Entries {
+		entry := &idx.Entries[i]
+		copy(entry.SHA[:], data[shaTable+i*20:])
+		entry.CRC32 = binary.BigEndian.Uint32(data[crcTable+i*4:])
+
+		offset := binary.BigEndian.Uint32(data[offsetTable+i*4:])
+		if offset&packIndexLargeOffset == 0 {
+			entry.Offset = uint64(offset)
+			continue
+		}
+		large := int(offset &^ packIndexLargeOffset)
+		if large >= largeOffsetCount {
+			return nil, fmt.Errorf("pack index has invalid 64-bit offset reference %d", large)
+		}
+		entry.Offset = binary.BigEndian.Uint64(data[largeOffsetTable+large*8:])
+	}
+	copy(idx.PackChecksum[:], data[len(data)-40:])
+
+	return idx, nil
+}
+
+// ReadPackIndex reads and decodes a .idx file
+func ReadPackIndex(path string) (*PackIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pack index: %w", err)
+	}
+	idx, err := DecodePackIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// Find returns the position of an object in the index, or -1 if it is
+// not there. Entries must be sorted
+func (idx *PackIndex) Find(sha [20]byte) int {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return bytes.Compare(idx.Entries[i].SHA[:], sha[:]) >= 0
+	})
+	if i < len(idx.Entries) && idx.Entries[i].SHA == sha {
+		return i
+	}
+	return -1
+}
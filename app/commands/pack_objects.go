@@ -0,0 +1,345 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PackObject is an object to be written into a pack
+type PackObject struct {
+	SHA  string
+	Name string // path the object was reached through, groups delta candidates
+
+	objType  GitObjectType
+	content  []byte
+	nameHash uint32
+
+	base   *PackObject
+	delta  []byte
+	depth  int
+	offset uint64
+	crc32  uint32
+}
+
+// PackOptions controls the delta search when writing a pack
+type PackOptions struct {
+	Window int // how many preceding objects are tried as delta bases
+	Depth  int // longest allowed delta chain
+}
+
+// DefaultPackOptions are the window and depth git uses by default
+var DefaultPackOptions = PackOptions{Window: 10, Depth: 50}
+
+// packNameHash is git's pack_name_hash: it weighs the last characters of
+// a path most, so files with the same name or extension sort together
+func packNameHash(name string) uint32 {
+	var hash uint32
+	for _, c := range []byte(name) {
+		if unicode.IsSpace(rune(c)) {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+	return hash
+}
+
+// findDeltas picks a delta base for each object from a sliding window over
+// the objects sorted by type, name hash and size
+func findDeltas(objects []*PackObject, opts PackOptions) {
+	sorted := make([]*PackObject, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.objType != b.objType {
+			return a.objType < b.objType
+		}
+		if a.nameHash != b.nameHash {
+			return a.nameHash < b.nameHash
+		}
+		return len(a.content) > len(b.content)
+	})
+
+	for i, object := range sorted {
+		for j := i - 1; j >= 0 && j >= i-opts.Window; j-- {
+			candidate := sorted[j]
+			if candidate.objType != object.objType {
+				break
+			}
+			if candidate.depth >= opts.Depth {
+				continue
+			}
+
+			// A delta is only kept if it is much smaller than the object
+			maxSize := len(object.content)/2 - 20
+			if object.delta != nil {
+				maxSize = len(object.delta) - 1
+			}
+			if maxSize <= 0 {
+				break
+			}
+			sizeDiff := len(object.content) - len(candidate.content)
+			if sizeDiff < 0 {
+				sizeDiff = -sizeDiff
+			}
+			if sizeDiff >= maxSize {
+				continue
+			}
+
+			delta := createDelta(candidate.content, object.content)
+			if len(delta) > maxSize {
+				continue
+			}
+			object.base = candidate
+			object.delta = delta
+			object.depth = candidate.depth + 1
+		}
+	}
+}
+
+// packWriter writes pack entries while tracking the offset and checksum
+type packWriter struct {
+	w      io.Writer
+	sum    hash.Hash
+	offset uint64
+}
+
+func (pw *packWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.sum.Write(p[:n])
+	pw.offset += uint64(n)
+	return n, err
+}
+
+// writeObject writes an object, making sure its delta base comes first
+// so that the entry can refer back to it with an OFS_DELTA
+func (pw *packWriter) writeObject(object *PackObject, written map[*PackObject]bool) error {
+	if written[object] {
+		return nil
+	}
+	if object.base != nil {
+		if err := pw.writeObject(object.base, written); err != nil {
+			return err
+		}
+	}
+
+	var entry bytes.Buffer
+	data := object.content
+	if object.base != nil {
+		data = object.delta
+		entry.Write(encodeObjectHeader(OBJ_OFS_DELTA, len(data)))
+		entry.Write(encodeOffset(int64(pw.offset - object.base.offset)))
+	} else {
+		entry.Write(encodeObjectHeader(packTypeOf(object.objType), len(data)))
+	}
+
+	zlibWriter := zlib.NewWriter(&entry)
+	if _, err := zlibWriter.Write(data); err != nil {
+		return fmt.Errorf("error compressing object %s: %w", object.SHA, err)
+	}
+	if err := zlibWriter.Close(); err != nil {
+		return fmt.Errorf("error compressing object %s: %w", object.SHA, err)
+	}
+
+	object.offset = pw.offset
+	object.crc32 = crc32.ChecksumIEEE(entry.Bytes())
+	if _, err := pw.Write(entry.Bytes()); err != nil {
+		return fmt.Errorf("error writing pack: %w", err)
+	}
+	written[object] = true
+	return nil
+}
+
+// WritePackfile reads the objects, searches for deltas and writes a pack
+// with them to w. It returns the index for the pack
+func WritePackfile(w io.Writer, objects []*PackObject, opts PackOptions) (*PackIndex, error) {
+	seen := make(map[string]bool, len(objects))
+	var unique []*PackObject
+	for _, object := range objects {
+		if seen[object.SHA] {
+			continue
+		}
+		seen[object.SHA] = true
+
+		objType, content, err := ReadObject(object.SHA)
+		if err != nil {
+			return nil, err
+		}
+		object.objType = objType
+		object.content = content
+		object.nameHash = packNameHash(object.Name)
+		unique = append(unique, object)
+	}
+
+	if opts.Window > 0 && opts.Depth > 0 {
+		findDeltas(unique, opts)
+	}
+
+	pw := &packWriter{w: w, sum: sha1.New()}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(unique)))
+	if _, err := pw.Write(header); err != nil {
+		return nil, fmt.Errorf("error writing pack: %w", err)
+	}
+
+	written := make(map[*PackObject]bool, len(unique))
+	for _, object := range unique {
+		if err := pw.writeObject(object, written); err != nil {
+			return nil, err
+		}
+	}
+
+	idx := &PackIndex{Entries: make([]PackIndexEntry, len(unique))}
+	copy(idx.PackChecksum[:], pw.sum.Sum(nil))
+	if _, err := w.Write(idx.PackChecksum[:]); err != nil {
+		return nil, fmt.Errorf("error writing pack: %w", err)
+	}
+
+	for i, object := range unique {
+		entry := &idx.Entries[i]
+		hex.Decode(entry.SHA[:], []byte(object.SHA))
+		entry.CRC32 = object.crc32
+		entry.Offset = object.offset
+	}
+	return idx, nil
+}
+
+// WritePackFiles writes the objects to <basePrefix>-<checksum>.pack and
+// its .idx, and returns the checksum
+func WritePackFiles(basePrefix string, objects []*PackObject, opts PackOptions) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(basePrefix), "tmp_pack_")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary pack: %w", err)
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	out := bufio.NewWriterSize(tmpFile, 64<<10)
+	idx, err := WritePackfile(out, objects, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := out.Flush(); err != nil {
+		return "", fmt.Errorf("error writing pack: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return "", fmt.Errorf("error syncing pack: %w", err)
+	}
+
+	checksum := hex.EncodeToString(idx.PackChecksum[:])
+	basePath := basePrefix + "-" + checksum
+
+	if err := os.Chmod(tmpFile.Name(), 0444); err != nil {
+		return "", fmt.Errorf("error setting pack permissions: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), basePath+".pack"); err != nil {
+		return "", fmt.Errorf("error storing pack: %w", err)
+	}
+	if err := writeFileAtomic(basePath+".idx", idx.Encode(), 0444); err != nil {
+		return "", fmt.Errorf("error writing pack index: %w", err)
+	}
+
+	return checksum, nil
+}
+
+type PackObjectsCommand struct{}
+
+func (c *PackObjectsCommand) GetName() string {
+	return "pack-objects"
+}
+
+func (c *PackObjectsCommand) Execute(cmd *Command) error {
+	// Format: pack-objects [--window=<n>] [--depth=<n>] (--stdout | <base-name>)
+	// Object names are read from stdin, one "<sha> [<path>]" per line
+	opts := DefaultPackOptions
+	var toStdout bool
+	var basePrefix string
+
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == "--stdout":
+			toStdout = true
+		case strings.HasPrefix(arg, "--window="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--window="))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid window: %s", arg)
+			}
+			opts.Window = n
+		case strings.HasPrefix(arg, "--depth="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--depth="))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid depth: %s", arg)
+			}
+			opts.Depth = n
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			basePrefix = arg
+		}
+	}
+
+	if toStdout == (basePrefix != "") {
+		return fmt.Errorf("usage: pack-objects [--window=<n>] [--depth=<n>] (--stdout | <base-name>)")
+	}
+
+	objects, err := readPackObjectList(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	if toStdout {
+		out := bufio.NewWriter(os.Stdout)
+		if _, err := WritePackfile(out, objects, opts); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+
+	checksum, err := WritePackFiles(basePrefix, objects, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(checksum)
+	return nil
+}
+
+// readPackObjectList reads "<sha> [<path>]" lines, as printed by
+// rev-list --objects
+func readPackObjectList(r io.Reader) ([]*PackObject, error) {
+	var objects []*PackObject
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sha, name, _ := strings.Cut(line, " ")
+		if !isObjectSHA(sha) {
+			return nil, fmt.Errorf("expected object name, got %q", line)
+		}
+		objects = append(objects, &PackObject{SHA: sha, Name: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading object list: %w", err)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Longest possible entry header: type and size varint, followed by either
+// an OFS_DELTA offset varint or a REF_DELTA base SHA-1
+const maxPackEntryHeader = 10 + 20
+
+// Packfile gives random access to the objects of a .pack through its .idx
+type Packfile struct {
+	Path  string
+	Index *PackIndex
+
+	file *os.File
+	db   *ObjectDatabase
+}
+
+// OpenPackfile opens a pack and reads the .idx next to it. REF_DELTA
+// bases that are not in the pack itself are looked up in db
+func OpenPackfile(packPath string, db *ObjectDatabase) (*Packfile, error) {
+	index, err := ReadPackIndex(strings.TrimSuffix(packPath, ".pack") + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening pack: %w", err)
+	}
+
+	return &Packfile{Path: packPath, Index: index, file: file, db: db}, nil
+}
+
+// Close releases the pack file
+func (p *Packfile) Close() error {
+	return p.file.Close()
+}
+
+// Contains reports whether the object is in this pack
+func (p *Packfile) Contains(sha [20]byte) bool {
+	return p.Index.Find(sha) >= 0
+}
+
+// Read returns the type and content of an object in the pack, rebuilding
+// it from its delta chain if needed, and checks it against the index
+func (p *Packfile) Read(sha [20]byte) (GitObjectType, []byte, error) {
+	i := p.Index.Find(sha)
+	if i < 0 {
+		return "", nil, fmt.Errorf("object %x not in %s", sha, p.Path)
+	}
+
+	object, err := p.readAt(p.Index.Entries[i].Offset, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expected := hex.EncodeToString(sha[:])
+	if err := verifyObjectHash(expected, object.objType, object.content, int(p.Index.Entries[i].Offset)); err != nil {
+		return "", nil, err
+	}
+	return object.objType, object.content, nil
+}
+
+// packEntry is the raw, unresolved form of one pack entry
+type packEntry struct {
+	objType    int
+	size       int
+	baseOffset uint64 // OFS_DELTA only
+	baseSHA    string // REF_DELTA only
+	data       []byte // object content, or delta instructions for deltas
+}
+
+// readEntry reads and inflates the entry whose header starts at offset
+func (p *Packfile) readEntry(offset uint64) (*packEntry, error) {
+	header := make([]byte, maxPackEntryHeader)
+	n, err := p.file.ReadAt(header, int64(offset))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading pack entry at offset %d: %w", offset, err)
+	}
+	header = header[:n]
+
+	objType, size, headerSize := parseObjectHeader(header)
+	if headerSize == 0 {
+		return nil, fmt.Errorf("invalid object header at offset %d", offset)
+	}
+	entry := &packEntry{objType: objType, size: size}
+
+	switch objType {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+	case OBJ_OFS_DELTA:
+		negOffset, offsetBytes := parseOffset(header[headerSize:])
+		if offsetBytes == 0 || negOffset <= 0 || uint64(negOffset) > offset {
+			return nil, fmt.Errorf("invalid OFS_DELTA base offset at offset %d", offset)
+		}
+		entry.baseOffset = offset - uint64(negOffset)
+		headerSize += offsetBytes
+	case OBJ_REF_DELTA:
+		if headerSize+20 > len(header) {
+			return nil, fmt.Errorf("truncated REF_DELTA base at offset %d", offset)
+		}
+		entry.baseSHA = hex.EncodeToString(header[headerSize : headerSize+20])
+		headerSize += 20
+	default:
+		return nil, fmt.Errorf("unknown object type %d at offset %d", objType, offset)
+	}
+
+	section := io.NewSectionReader(p.file, int64(offset)+int64(headerSize), 1<<62)
+	zlibReader, err := zlib.NewReader(bufio.NewReader(section))
+	if err != nil {
+		return nil, fmt.Errorf("error creating zlib reader at offset %d: %w", offset, err)
+	}
+	defer zlibReader.Close()
+
+	entry.data, err = io.ReadAll(zlibReader)
+	if err != nil {
+		return nil, fmt.Errorf("error inflating object at offset %d: %w", offset, err)
+	}
+	if len(entry.data) != size {
+		return nil, fmt.Errorf("object size mismatch at offset %d: header says %d bytes, inflated %d", offset, size, len(entry.data))
+	}
+
+	return entry, nil
+}
+
+// readAt resolves the object whose entry starts at offset
+func (p *Packfile) readAt(offset uint64, depth int) (packedObject, error) {
+	if object, exists := p.db.cache.get(p.Path, offset); exists {
+		return object, nil
+	}
+	if depth > maxDeltaDepth {
+		return packedObject{}, fmt.Errorf("delta chain too deep at offset %d", offset)
+	}
+
+	entry, err := p.readEntry(offset)
+	if err != nil {
+		return packedObject{}, err
+	}
+
+	var object packedObject
+	switch entry.objType {
+	case OBJ_OFS_DELTA, OBJ_REF_DELTA:
+		var base packedObject
+		if entry.objType == OBJ_OFS_DELTA {
+			base, err = p.readAt(entry.baseOffset, depth+1)
+		} else {
+			base, err = p.readBase(entry.baseSHA, depth+1)
+		}
+		if err != nil {
+			return packedObject{}, err
+		}
+
+		result, err := applyDelta(base.content, entry.data)
+		if err != nil {
+			return packedObject{}, fmt.Errorf("error applying delta at offset %d: %w", offset, err)
+		}
+		object = packedObject{objType: base.objType, content: result}
+
+	default:
+		objType, err := packObjectType(entry.objType)
+		if err != nil {
+			return packedObject{}, err
+		}
+		object = packedObject{objType: objType, content: entry.data}
+	}
+
+	p.db.cache.add(p.Path, offset, object)
+	return object, nil
+}
+
+// readBase resolves a REF_DELTA base, preferring this pack
+func (p *Packfile) readBase(sha string, depth int) (packedObject, error) {
+	var raw [20]byte
+	if _, err := hex.Decode(raw[:], []byte(sha)); err != nil {
+		return packedObject{}, fmt.Errorf("invalid REF_DELTA base %q", sha)
+	}
+
+	if i := p.Index.Find(raw); i >= 0 {
+		return p.readAt(p.Index.Entries[i].Offset, depth)
+	}
+
+	objType, content, err := p.db.Read(sha)
+	if err != nil {
+		return packedObject{}, fmt.Errorf("error reading REF_DELTA base %s: %w", sha, err)
+	}
+	return packedObject{objType: objType, content: content}, nil
+}
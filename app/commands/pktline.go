@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Largest pkt-line allowed by the protocol, length header included
+const maxPktLineLength = 65520
+
+// PktLineReader reads pkt-line framed packets from a stream
+type PktLineReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// NewPktLineReader returns a reader for the pkt-lines in r
+func NewPktLineReader(r io.Reader) *PktLineReader {
+	return &PktLineReader{r: bufio.NewReaderSize(r, maxPktLineLength), buf: make([]byte, maxPktLineLength)}
+}
+
+// ReadPacket returns the payload of the next packet. Flush packets are
+// reported with flush set and a nil payload. The payload is only valid
+// until the next call
+func (p *PktLineReader) ReadPacket() (payload []byte, flush bool, err error) {
+	header := p.buf[:4]
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, false, fmt.Errorf("truncated pkt-line header")
+		}
+		return nil, false, err
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pkt-line length: %q", header)
+	}
+
+	// Flush (0000) and the protocol v2 delimiters carry no payload
+	if length < 4 {
+		return nil, true, nil
+	}
+	if length > maxPktLineLength {
+		return nil, false, fmt.Errorf("pkt-line too long: %d", length)
+	}
+
+	payload = p.buf[4:length]
+	if _, err := io.ReadFull(p.r, payload); err != nil {
+		return nil, false, fmt.Errorf("truncated pkt-line: %w", err)
+	}
+	return payload, false, nil
+}
+
+// sideBandReader demultiplexes a side-band-64k response. Channel 1 is
+// returned as pack data, channel 2 is progress and goes to Progress, and
+// channel 3 is a fatal error from the server
+type sideBandReader struct {
+	pkt      *PktLineReader
+	Progress io.Writer
+
+	pending []byte
+	done    bool
+}
+
+// newSideBandReader returns a reader for the pack data multiplexed in r
+func newSideBandReader(r io.Reader) *sideBandReader {
+	return &sideBandReader{pkt: NewPktLineReader(r), Progress: os.Stderr}
+}
+
+func (s *sideBandReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		payload, flush, err := s.pkt.ReadPacket()
+		if err == io.EOF {
+			s.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if flush {
+			// The pack stream ends with a flush packet
+			s.done = true
+			continue
+		}
+
+		// Negotiation replies come before the multiplexed stream
+		if bytes.Equal(payload, []byte("NAK\n")) || bytes.HasPrefix(payload, []byte("ACK ")) {
+			continue
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		switch channel, data := payload[0], payload[1:]; channel {
+		case 1:
+			s.pending = data
+		case 2:
+			if s.Progress != nil {
+				s.Progress.Write(data)
+			}
+		case 3:
+			return 0, fmt.Errorf("remote error: %s", bytes.TrimSpace(data))
+		default:
+			return 0, fmt.Errorf("invalid side-band channel %d", channel)
+		}
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
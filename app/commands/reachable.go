@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// refRoots returns the objects HEAD, every ref and the index point at,
+// without duplicates
+func refRoots() ([]string, error) {
+	refs, err := refStore.List("refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var roots []string
+	if head, err := refStore.Resolve("HEAD"); err == nil {
+		seen[head] = true
+		roots = append(roots, head)
+	}
+
+	candidates := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		candidates = append(candidates, ref.SHA)
+	}
+	staged, err := indexRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sha := range append(candidates, staged...) {
+		if !seen[sha] {
+			seen[sha] = true
+			roots = append(roots, sha)
+		}
+	}
+
+	return roots, nil
+}
+
+// indexRoots returns the objects the index keeps alive: the blobs of its
+// entries and the trees of valid cache-tree nodes. Gitlinks and
+// intent-to-add entries name nothing stored in this repository
+func indexRoots() ([]string, error) {
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, entry := range index.Entries {
+		if entry.Mode == 0160000 || entry.ExtendedFlags&indexFlagIntentToAdd != 0 {
+			continue
+		}
+		roots = append(roots, hex.EncodeToString(entry.SHA[:]))
+	}
+
+	// An invalidated node may still have valid subtrees
+	var walkCacheTree func(node *CacheTree)
+	walkCacheTree = func(node *CacheTree) {
+		if node == nil {
+			return
+		}
+		if node.Valid() {
+			roots = append(roots, hex.EncodeToString(node.SHA[:]))
+		}
+		for _, subtree := range node.Subtrees {
+			walkCacheTree(subtree)
+		}
+	}
+	walkCacheTree(index.Tree)
+
+	return roots, nil
+}
+
+// reachableObjects lists every object reachable from the given roots, the
+// way rev-list --objects does: commits and tags first, then the trees and
+// blobs they reference, each with the path it was first reached through.
+// Submodule commits (gitlinks) are not followed
+func reachableObjects(roots []string) ([]*PackObject, error) {
+	seen := make(map[string]bool)
+	var objects, treeObjects []*PackObject
+
+	var walkTree func(sha, path string) error
+	walkTree = func(sha, path string) error {
+		if seen[sha] {
+			return nil
+		}
+		seen[sha] = true
+		treeObjects = append(treeObjects, &PackObject{SHA: sha, Name: path})
+
+		tree, err := ReadTree(sha)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range tree.Entries {
+			entrySHA := hex.EncodeToString(entry.SHA)
+			entryPath := entry.Name
+			if path != "" {
+				entryPath = path + "/" + entry.Name
+			}
+
+			switch entry.Mode {
+			case "40000":
+				if err := walkTree(entrySHA, entryPath); err != nil {
+					return err
+				}
+			case "160000":
+				// Gitlinks point into another repository
+			default:
+				if !seen[entrySHA] {
+					seen[entrySHA] = true
+					treeObjects = append(treeObjects, &PackObject{SHA: entrySHA, Name: entryPath})
+				}
+			}
+		}
+		return nil
+	}
+
+	pending := append([]string(nil), roots...)
+	var trees []string
+	for len(pending) > 0 {
+		sha := pending[0]
+		pending = pending[1:]
+		if seen[sha] {
+			continue
+		}
+
+		objectType, content, err := ReadObject(sha)
+		if err != nil {
+			return nil, err
+		}
+
+		switch objectType {
+		case CommitObject:
+			seen[sha] = true
+			objects = append(objects, &PackObject{SHA: sha})
+			commit, err := ParseCommit(content)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing commit %s: %w", sha, err)
+			}
+			trees = append(trees, commit.Tree)
+			pending = append(pending, commit.Parents...)
+		case TagObject:
+			seen[sha] = true
+			objects = append(objects, &PackObject{SHA: sha})
+			tag, err := ParseTag(content)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing tag %s: %w", sha, err)
+			}
+			pending = append(pending, tag.Object)
+		case TreeObject:
+			trees = append(trees, sha)
+		default:
+			seen[sha] = true
+			treeObjects = append(treeObjects, &PackObject{SHA: sha})
+		}
+	}
+
+	for _, tree := range trees {
+		if err := walkTree(tree, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(objects, treeObjects...), nil
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ResolveRevision turns a full object SHA, HEAD or a ref name into an object SHA.
+// Short ref names are looked up under refs/, refs/tags/, refs/heads/ and
+// refs/remotes/, then as refs/remotes/<name>/HEAD, in that order, the same
+// way git does
+func ResolveRevision(name string) (string, error) {
+	if isObjectSHA(name) {
+		return name, nil
+	}
+
+	candidates := []string{name}
+	if name != "HEAD" && !strings.HasPrefix(name, "refs/") {
+		candidates = append(candidates, "refs/"+name, "refs/tags/"+name, "refs/heads/"+name,
+			"refs/remotes/"+name, "refs/remotes/"+name+"/HEAD")
+	}
+
+	for _, ref := range candidates {
+		sha, err := refStore.Resolve(ref)
+		if err == nil {
+			return sha, nil
+		}
+		if !isRefNotFound(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("not a valid object name: %s", name)
+}
+
+// ResolveTree resolves a revision to a tree SHA, peeling tags and commits
+func ResolveTree(name string) (string, error) {
+	sha, err := ResolveRevision(name)
+	if err != nil {
+		return "", err
+	}
+
+	sha, objectType, err := peelObject(sha)
+	if err != nil {
+		return "", err
+	}
+
+	switch objectType {
+	case TreeObject:
+		return sha, nil
+	case CommitObject:
+		_, content, err := ReadObject(sha)
+		if err != nil {
+			return "", err
+		}
+		commit, err := ParseCommit(content)
+		if err != nil {
+			return "", fmt.Errorf("error parsing commit %s: %w", sha, err)
+		}
+		return commit.Tree, nil
+	default:
+		return "", fmt.Errorf("not a tree object: %s", name)
+	}
+}
+
+// isObjectSHA reports whether s is a full hex-encoded SHA-1
+func isObjectSHA(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
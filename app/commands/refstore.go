@@ -0,0 +1,700 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// zeroSHA stands for "no object" in ref updates: an old value that must
+// not exist yet, or a new value that deletes the ref
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// Symbolic refs pointing further than this are treated as a loop
+const maxSymrefDepth = 5
+
+// RefStore reads and writes the refs of a repository: loose refs, one
+// file per ref under the git directory, and the refs packed into
+// packed-refs, which loose refs take precedence over
+type RefStore struct {
+	Dir string
+}
+
+// NewRefStore returns a ref store for the given git directory
+func NewRefStore(dir string) *RefStore {
+	return &RefStore{Dir: dir}
+}
+
+// refStore is the ref store of the repository in the working directory
+var refStore = NewRefStore(".git")
+
+// Ref is a ref as stored
+type Ref struct {
+	Name   string
+	SHA    string // the object it points at; for a symbolic ref, the object its target resolves to, if any
+	Target string // the ref a symbolic ref points at, "" for a regular ref
+	Peeled string // what an annotated tag peels to, when packed-refs records it
+}
+
+// RefNotFoundError is returned when neither a loose ref nor packed-refs has the ref
+type RefNotFoundError struct {
+	Name string
+}
+
+func (e *RefNotFoundError) Error() string {
+	return fmt.Sprintf("ref %s not found", e.Name)
+}
+
+// isRefNotFound reports whether err means that a ref does not exist
+func isRefNotFound(err error) bool {
+	var notFound *RefNotFoundError
+	return errors.As(err, &notFound)
+}
+
+// path returns the loose file of a ref
+func (s *RefStore) path(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(name))
+}
+
+// Read returns a ref without following it when it is symbolic
+func (s *RefStore) Read(name string) (*Ref, error) {
+	ref, err := s.readLoose(name)
+	if !isRefNotFound(err) {
+		return ref, err
+	}
+
+	packed, err := s.readPacked()
+	if err != nil {
+		return nil, err
+	}
+	if ref, exists := packed[name]; exists {
+		return ref, nil
+	}
+	return nil, &RefNotFoundError{Name: name}
+}
+
+// readLoose reads the loose file of a ref: an object SHA or "ref: <target>"
+func (s *RefStore) readLoose(name string) (*Ref, error) {
+	content, err := os.ReadFile(s.path(name))
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) || isDirectoryError(err) {
+		return nil, &RefNotFoundError{Name: name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading ref %s: %w", name, err)
+	}
+
+	value := strings.TrimSpace(string(content))
+	if target, isSymbolic := strings.CutPrefix(value, "ref: "); isSymbolic {
+		return &Ref{Name: name, Target: strings.TrimSpace(target)}, nil
+	}
+	if !isObjectSHA(value) {
+		return nil, fmt.Errorf("invalid ref %s: %q", name, value)
+	}
+	return &Ref{Name: name, SHA: value}, nil
+}
+
+// isDirectoryError reports whether reading a file failed because it is a
+// directory, as for "refs/heads/a" when "refs/heads/a/b" exists
+func isDirectoryError(err error) bool {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	info, statErr := os.Stat(pathErr.Path)
+	return statErr == nil && info.IsDir()
+}
+
+// Follow returns the ref a name ends up at after following symbolic refs.
+// The final ref need not exist, as for HEAD on an unborn branch
+func (s *RefStore) Follow(name string) (string, error) {
+	for depth := 0; depth <= maxSymrefDepth; depth++ {
+		ref, err := s.Read(name)
+		if isRefNotFound(err) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if ref.Target == "" {
+			return name, nil
+		}
+		name = ref.Target
+	}
+	return "", fmt.Errorf("symbolic ref %s nested too deeply", name)
+}
+
+// Resolve returns the object a ref points at, following symbolic refs
+func (s *RefStore) Resolve(name string) (string, error) {
+	final, err := s.Follow(name)
+	if err != nil {
+		return "", err
+	}
+	ref, err := s.Read(final)
+	if err != nil {
+		return "", err
+	}
+	return ref.SHA, nil
+}
+
+// ReadSymbolic returns the ref a symbolic ref such as HEAD points at,
+// or "" when it holds an object SHA
+func (s *RefStore) ReadSymbolic(name string) (string, error) {
+	ref, err := s.Read(name)
+	if err != nil {
+		return "", err
+	}
+	return ref.Target, nil
+}
+
+// List returns the refs whose names start with prefix, sorted by name.
+// Symbolic refs are included with the object their target resolves to;
+// those that resolve to nothing are skipped with a warning
+func (s *RefStore) List(prefix string) ([]*Ref, error) {
+	packed, err := s.readPacked()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]*Ref)
+	for name, ref := range packed {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = ref
+		}
+	}
+
+	refsDir := filepath.Join(s.Dir, "refs")
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		ref, err := s.readLoose(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = ref
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs: %w", err)
+	}
+
+	list := make([]*Ref, 0, len(refs))
+	for _, ref := range refs {
+		if ref.Target != "" {
+			sha, err := s.Resolve(ref.Name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: ignoring broken ref %s\n", ref.Name)
+				continue
+			}
+			ref.SHA = sha
+		}
+		list = append(list, ref)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// packedRefsPath is the file refs are packed into
+func (s *RefStore) packedRefsPath() string {
+	return filepath.Join(s.Dir, "packed-refs")
+}
+
+// readPacked parses packed-refs. A "^<sha>" line records what the
+// annotated tag on the line before it peels to
+func (s *RefStore) readPacked() (map[string]*Ref, error) {
+	refs := make(map[string]*Ref)
+
+	content, err := os.ReadFile(s.packedRefsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading packed-refs: %w", err)
+	}
+
+	var previous *Ref
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if peeled, isPeeled := strings.CutPrefix(line, "^"); isPeeled {
+			if previous == nil || !isObjectSHA(peeled) {
+				return nil, fmt.Errorf("invalid packed-refs line: %q", line)
+			}
+			previous.Peeled = peeled
+			continue
+		}
+
+		sha, name, found := strings.Cut(line, " ")
+		if !found || !isObjectSHA(sha) {
+			return nil, fmt.Errorf("invalid packed-refs line: %q", line)
+		}
+		previous = &Ref{Name: name, SHA: sha}
+		refs[name] = previous
+	}
+
+	return refs, nil
+}
+
+// encodePacked formats packed-refs, peeling every annotated tag so that
+// readers do not have to open the tag objects. The header only claims the
+// refs are peeled when every one could be: readers take a missing "^"
+// line under such a header to mean the ref is not an annotated tag
+func encodePacked(refs map[string]*Ref) []byte {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body strings.Builder
+	fullyPeeled := true
+	for _, name := range names {
+		ref := refs[name]
+		fmt.Fprintf(&body, "%s %s\n", ref.SHA, name)
+
+		peeled := ref.Peeled
+		if peeled == "" {
+			var err error
+			if peeled, _, err = peelObject(ref.SHA); err != nil {
+				fullyPeeled = false
+			}
+		}
+		if peeled != "" && peeled != ref.SHA {
+			fmt.Fprintf(&body, "^%s\n", peeled)
+		}
+	}
+
+	header := "# pack-refs with: sorted \n"
+	if fullyPeeled {
+		header = "# pack-refs with: peeled fully-peeled sorted \n"
+	}
+	return []byte(header + body.String())
+}
+
+// peelObject follows annotated tags to the object they finally point at
+func peelObject(sha string) (string, GitObjectType, error) {
+	// Tags can point at tags; a chain this long is surely a loop
+	for depth := 0; depth < 100; depth++ {
+		objectType, content, err := ReadObject(sha)
+		if err != nil {
+			return "", "", err
+		}
+		if objectType != TagObject {
+			return sha, objectType, nil
+		}
+		tag, err := ParseTag(content)
+		if err != nil {
+			return "", "", fmt.Errorf("error parsing tag %s: %w", sha, err)
+		}
+		sha = tag.Object
+	}
+	return "", "", fmt.Errorf("tag chain too long at %s", sha)
+}
+
+// Pack moves every regular loose ref into packed-refs and removes the
+// loose files. Symbolic refs stay loose
+func (s *RefStore) Pack() error {
+	packedLock, err := s.lock(s.packedRefsPath())
+	if err != nil {
+		return err
+	}
+	defer packedLock.rollback()
+
+	refs, err := s.readPacked()
+	if err != nil {
+		return err
+	}
+	list, err := s.List("refs/")
+	if err != nil {
+		return err
+	}
+
+	var loose []*Ref
+	for _, ref := range list {
+		if ref.Target != "" {
+			continue
+		}
+		if packed, exists := refs[ref.Name]; !exists || packed.SHA != ref.SHA {
+			refs[ref.Name] = &Ref{Name: ref.Name, SHA: ref.SHA}
+		}
+		loose = append(loose, ref)
+	}
+	if err := packedLock.commit(encodePacked(refs)); err != nil {
+		return err
+	}
+
+	// A ref updated since it was listed keeps its newer loose value
+	for _, ref := range loose {
+		lock, err := s.lock(s.path(ref.Name))
+		if err != nil {
+			continue
+		}
+		if current, err := s.readLoose(ref.Name); err == nil && current.SHA == ref.SHA {
+			os.Remove(s.path(ref.Name))
+			s.removeEmptyDirs(ref.Name)
+		}
+		lock.rollback()
+	}
+	return nil
+}
+
+// removeEmptyDirs removes the directories of a deleted ref that are left
+// empty, up to refs/<kind>/
+func (s *RefStore) removeEmptyDirs(name string) {
+	for dir := filepath.Dir(s.path(name)); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(s.Dir, dir)
+		if err != nil || strings.Count(filepath.ToSlash(rel), "/") < 2 {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// refLock is a held <file>.lock, into which the new content of the file
+// is written before being renamed over it
+type refLock struct {
+	path string
+	file *os.File
+
+	// The topmost directory created to hold the lock, removed again if
+	// the lock is released without writing anything
+	createdDir string
+}
+
+// lock creates <path>.lock exclusively, creating parent directories as needed
+func (s *RefStore) lock(path string) (*refLock, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		rel, _ := filepath.Rel(s.Dir, path)
+		return nil, fmt.Errorf("cannot lock ref '%s': there is a non-empty directory '%s' blocking it", filepath.ToSlash(rel), path)
+	}
+
+	createdDir := ""
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) || dir == filepath.Dir(dir) {
+			break
+		}
+		createdDir = dir
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create directory for '%s': %w", path, err)
+	}
+
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		removeCreatedDirs(filepath.Dir(path), createdDir)
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("unable to create '%s': file exists; another git process seems to be running", lockPath)
+		}
+		return nil, fmt.Errorf("unable to create '%s': %w", lockPath, err)
+	}
+	return &refLock{path: path, file: file, createdDir: createdDir}, nil
+}
+
+// removeCreatedDirs removes dir and its parents up to top, as long as they
+// are empty. Nothing is removed when top is ""
+func removeCreatedDirs(dir, top string) {
+	if top == "" {
+		return
+	}
+	for os.Remove(dir) == nil && dir != top {
+		dir = filepath.Dir(dir)
+	}
+}
+
+// commit writes the new content and renames the lock into place
+func (l *refLock) commit(content []byte) error {
+	lockPath := l.path + ".lock"
+	if _, err := l.file.Write(content); err != nil {
+		l.rollback()
+		return fmt.Errorf("error writing %s: %w", l.path, err)
+	}
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = os.Rename(lockPath, l.path)
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("error writing %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// rollback releases the lock without changing the file. It is a no-op
+// once the lock was committed
+func (l *refLock) rollback() {
+	if l.file == nil {
+		return
+	}
+	l.file.Close()
+	l.file = nil
+	os.Remove(l.path + ".lock")
+	removeCreatedDirs(filepath.Dir(l.path), l.createdDir)
+}
+
+// WriteSymbolic points a symbolic ref such as HEAD at another ref
+func (s *RefStore) WriteSymbolic(name, target string) error {
+	lock, err := s.lock(s.path(name))
+	if err != nil {
+		return err
+	}
+	return lock.commit([]byte("ref: " + target + "\n"))
+}
+
+// DeleteSymbolic removes a symbolic ref itself, not the ref it points at
+func (s *RefStore) DeleteSymbolic(name string) error {
+	ref, err := s.readLoose(name)
+	if err != nil {
+		return err
+	}
+	if ref.Target == "" {
+		return fmt.Errorf("ref %s is not a symbolic ref", name)
+	}
+	if err := os.Remove(s.path(name)); err != nil {
+		return fmt.Errorf("error deleting ref %s: %w", name, err)
+	}
+	s.removeEmptyDirs(name)
+	return nil
+}
+
+// Update sets a ref to newSHA if it still points at oldSHA. An empty
+// oldSHA skips the check and zeroSHA requires the ref not to exist yet
+func (s *RefStore) Update(name, newSHA, oldSHA string) error {
+	tx := s.Begin()
+	tx.Update(name, newSHA, oldSHA, false)
+	return tx.Commit()
+}
+
+// Delete removes a ref, loose and packed, if it still points at oldSHA.
+// An empty oldSHA skips the check
+func (s *RefStore) Delete(name, oldSHA string) error {
+	tx := s.Begin()
+	tx.Delete(name, oldSHA, false)
+	return tx.Commit()
+}
+
+// RefTransaction is a set of ref updates applied together: every ref is
+// locked and checked against its expected value before any is changed
+type RefTransaction struct {
+	store   *RefStore
+	updates []*refUpdate
+}
+
+// refUpdate is one change of a transaction
+type refUpdate struct {
+	name    string
+	newSHA  string // zeroSHA deletes the ref, "" only checks it
+	oldSHA  string // zeroSHA: must not exist, "": not checked
+	noDeref bool   // change a symbolic ref itself rather than its target
+
+	final string // the ref actually changed, once symbolic refs are followed
+	lock  *refLock
+}
+
+// Begin starts a transaction
+func (s *RefStore) Begin() *RefTransaction {
+	return &RefTransaction{store: s}
+}
+
+// Update queues setting a ref to newSHA, expecting oldSHA
+func (t *RefTransaction) Update(name, newSHA, oldSHA string, noDeref bool) {
+	t.updates = append(t.updates, &refUpdate{name: name, newSHA: newSHA, oldSHA: oldSHA, noDeref: noDeref})
+}
+
+// Create queues creating a ref that must not exist yet
+func (t *RefTransaction) Create(name, newSHA string, noDeref bool) {
+	t.Update(name, newSHA, zeroSHA, noDeref)
+}
+
+// Delete queues deleting a ref, expecting oldSHA
+func (t *RefTransaction) Delete(name, oldSHA string, noDeref bool) {
+	t.Update(name, zeroSHA, oldSHA, noDeref)
+}
+
+// Verify queues checking that a ref points at oldSHA without changing it
+func (t *RefTransaction) Verify(name, oldSHA string, noDeref bool) {
+	t.Update(name, "", oldSHA, noDeref)
+}
+
+// Commit locks every ref, and packed-refs when refs are deleted, checks
+// the expected values and applies the updates. Nothing is changed unless
+// every check passes. Should writing fail after that, updates are applied
+// before packed-refs is rewritten, and loose files of deleted refs are
+// removed last, so a deleted ref is never left with its packed value
+func (t *RefTransaction) Commit() error {
+	s := t.store
+	var packedLock *refLock
+	defer func() {
+		for _, update := range t.updates {
+			if update.lock != nil {
+				update.lock.rollback()
+			}
+		}
+		if packedLock != nil {
+			packedLock.rollback()
+		}
+	}()
+
+	seen := make(map[string]bool)
+	deleting := false
+	for _, update := range t.updates {
+		update.final = update.name
+		if !update.noDeref {
+			final, err := s.Follow(update.name)
+			if err != nil {
+				return fmt.Errorf("cannot lock ref '%s': %w", update.name, err)
+			}
+			update.final = final
+		}
+		if seen[update.final] {
+			return fmt.Errorf("multiple updates for ref '%s' not allowed", update.final)
+		}
+		seen[update.final] = true
+
+		if update.final != "HEAD" && !validRefName(update.final) {
+			return fmt.Errorf("invalid ref name '%s'", update.final)
+		}
+		if err := checkRefTarget(update.final, update.newSHA); err != nil {
+			return err
+		}
+
+		lock, err := s.lock(s.path(update.final))
+		if err != nil {
+			return err
+		}
+		update.lock = lock
+
+		// Only checked once locked, so nobody can change it in between
+		if err := t.checkOld(update); err != nil {
+			return err
+		}
+		if update.newSHA == zeroSHA {
+			deleting = true
+		}
+	}
+
+	// Deleted refs must also go from packed-refs, or they would reappear
+	var packed []byte
+	if deleting {
+		var err error
+		if packedLock, packed, err = t.prepareDeletePacked(); err != nil {
+			return err
+		}
+	}
+
+	for _, update := range t.updates {
+		if update.newSHA != "" && update.newSHA != zeroSHA {
+			if err := update.lock.commit([]byte(update.newSHA + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+	if packed != nil {
+		if err := packedLock.commit(packed); err != nil {
+			return err
+		}
+	}
+	for _, update := range t.updates {
+		if update.newSHA != zeroSHA {
+			continue
+		}
+		if err := os.Remove(s.path(update.final)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error deleting ref %s: %w", update.final, err)
+		}
+		update.lock.rollback()
+		s.removeEmptyDirs(update.final)
+	}
+	return nil
+}
+
+// checkRefTarget refuses to point a ref at a missing object, or a branch
+// at anything but a commit
+func checkRefTarget(name, sha string) error {
+	if sha == "" || sha == zeroSHA {
+		return nil
+	}
+	objectType, _, err := ReadObject(sha)
+	if err != nil {
+		return fmt.Errorf("trying to write ref '%s' with nonexistent object %s", name, sha)
+	}
+	if objectType != CommitObject && strings.HasPrefix(name, "refs/heads/") {
+		return fmt.Errorf("trying to write non-commit object %s to branch '%s'", sha, name)
+	}
+	return nil
+}
+
+// checkOld compares a locked ref with the value the update expects
+func (t *RefTransaction) checkOld(update *refUpdate) error {
+	current, err := t.store.Read(update.final)
+	if err != nil && !isRefNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case update.oldSHA == "":
+		if update.newSHA == zeroSHA && !exists {
+			return fmt.Errorf("cannot delete ref '%s': %w", update.name, err)
+		}
+	case update.oldSHA == zeroSHA:
+		if exists {
+			return fmt.Errorf("cannot lock ref '%s': reference already exists", update.name)
+		}
+	case !exists:
+		return fmt.Errorf("cannot lock ref '%s': unable to resolve reference '%s'", update.name, update.final)
+	case current.SHA != update.oldSHA:
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", update.name, current.SHA, update.oldSHA)
+	}
+	return nil
+}
+
+// prepareDeletePacked locks packed-refs and returns the lock with the
+// content it should get without the refs being deleted, or nil content
+// when none of them is packed
+func (t *RefTransaction) prepareDeletePacked() (*refLock, []byte, error) {
+	s := t.store
+	lock, err := s.lock(s.packedRefsPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	packed, err := s.readPacked()
+	if err != nil {
+		return lock, nil, err
+	}
+
+	changed := false
+	for _, update := range t.updates {
+		if _, exists := packed[update.final]; exists && update.newSHA == zeroSHA {
+			delete(packed, update.final)
+			changed = true
+		}
+	}
+	if !changed {
+		return lock, nil, nil
+	}
+	return lock, encodePacked(packed), nil
+}
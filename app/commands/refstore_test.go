@@ -0,0 +1,288 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRepository is a scratch repository holding a commit and two
+// annotated tags, the second pointing at the first
+type testRepository struct {
+	store               *RefStore
+	commit, tag, nested string
+}
+
+// newTestRepository creates the repository and makes it the working
+// directory, where the object database is looked up
+func newTestRepository(t *testing.T) *testRepository {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := initRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := string(WriteGitObject(TreeObject, nil, true))
+	commit := string(WriteGitObject(CommitObject, []byte("tree "+tree+
+		"\nauthor A <a@example.com> 0 +0000\ncommitter A <a@example.com> 0 +0000\n\nfirst\n"), true))
+	tagObject := func(target string, objectType GitObjectType, name string) string {
+		return string(WriteGitObject(TagObject, []byte(fmt.Sprintf(
+			"object %s\ntype %s\ntag %s\ntagger A <a@example.com> 0 +0000\n\nmessage\n", target, objectType, name)), true))
+	}
+	tag := tagObject(commit, CommitObject, "v1")
+	nested := tagObject(tag, TagObject, "v2")
+
+	return &testRepository{store: NewRefStore(".git"), commit: commit, tag: tag, nested: nested}
+}
+
+// writePackedRefs replaces packed-refs
+func (r *testRepository) writePackedRefs(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(r.store.packedRefsPath(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackedRefsRoundTrip(t *testing.T) {
+	repo := newTestRepository(t)
+	const missing = "1111111111111111111111111111111111111111"
+
+	tests := []struct {
+		name   string
+		refs   map[string]*Ref
+		header string
+		peeled map[string]string // what each ref should be recorded as peeling to
+	}{
+		{
+			name:   "empty",
+			refs:   map[string]*Ref{},
+			header: "# pack-refs with: peeled fully-peeled sorted \n",
+		},
+		{
+			name: "branches and tags",
+			refs: map[string]*Ref{
+				"refs/heads/main":       {Name: "refs/heads/main", SHA: repo.commit},
+				"refs/heads/a/b":        {Name: "refs/heads/a/b", SHA: repo.commit},
+				"refs/tags/v1":          {Name: "refs/tags/v1", SHA: repo.tag},
+				"refs/tags/v2":          {Name: "refs/tags/v2", SHA: repo.nested},
+				"refs/tags/lightweight": {Name: "refs/tags/lightweight", SHA: repo.commit},
+			},
+			header: "# pack-refs with: peeled fully-peeled sorted \n",
+			peeled: map[string]string{"refs/tags/v1": repo.commit, "refs/tags/v2": repo.commit},
+		},
+		{
+			name: "peeled values already known are kept",
+			refs: map[string]*Ref{
+				"refs/tags/v1": {Name: "refs/tags/v1", SHA: missing, Peeled: repo.commit},
+			},
+			header: "# pack-refs with: peeled fully-peeled sorted \n",
+			peeled: map[string]string{"refs/tags/v1": repo.commit},
+		},
+		{
+			name: "an object that cannot be peeled drops the traits",
+			refs: map[string]*Ref{
+				"refs/tags/v1":      {Name: "refs/tags/v1", SHA: repo.tag},
+				"refs/tags/missing": {Name: "refs/tags/missing", SHA: missing},
+			},
+			header: "# pack-refs with: sorted \n",
+			peeled: map[string]string{"refs/tags/v1": repo.commit},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodePacked(tt.refs)
+			if !strings.HasPrefix(string(data), tt.header) {
+				t.Errorf("header of\n%s\nwant %q", data, tt.header)
+			}
+
+			repo.writePackedRefs(t, string(data))
+			decoded, err := repo.store.readPacked()
+			if err != nil {
+				t.Fatalf("readPacked: %v", err)
+			}
+
+			want := make(map[string]*Ref)
+			for name, ref := range tt.refs {
+				want[name] = &Ref{Name: name, SHA: ref.SHA, Peeled: tt.peeled[name]}
+			}
+			if !reflect.DeepEqual(decoded, want) {
+				t.Errorf("decoded %v, want %v", decoded, want)
+			}
+
+			if again := encodePacked(decoded); string(again) != string(data) {
+				t.Errorf("re-encoding changed packed-refs:\n%s\nwant\n%s", again, data)
+			}
+		})
+	}
+}
+
+func TestReadPackedRejectsMalformed(t *testing.T) {
+	repo := newTestRepository(t)
+	sha := repo.commit
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"peeled line before any ref", "^" + sha + "\n"},
+		{"short SHA", "abc123 refs/heads/main\n"},
+		{"missing name", sha + "\n"},
+		{"invalid peeled SHA", sha + " refs/tags/v1\n^nothex\n"},
+		{"empty SHA", " refs/heads/main\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.writePackedRefs(t, "# pack-refs with: peeled fully-peeled sorted \n"+tt.content)
+			if _, err := repo.store.readPacked(); err == nil || !strings.Contains(err.Error(), "invalid packed-refs line") {
+				t.Errorf("readPacked error = %v, want an invalid line error", err)
+			}
+		})
+	}
+}
+
+// refDirectories lists the directories under refs/
+func refDirectories(t *testing.T, store *RefStore) []string {
+	t.Helper()
+	var dirs []string
+	err := filepath.WalkDir(filepath.Join(store.Dir, "refs"), func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			rel, _ := filepath.Rel(store.Dir, path)
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dirs
+}
+
+func TestRefTransaction(t *testing.T) {
+	repo := newTestRepository(t)
+	store := repo.store
+	initialDirs := refDirectories(t, store)
+
+	packed := repo.commit + " refs/heads/packed/deep\n" + repo.commit + " refs/heads/other\n"
+	repo.writePackedRefs(t, "# pack-refs with: peeled fully-peeled sorted \n"+packed)
+
+	// Deleting a packed-only ref rewrites packed-refs and leaves no directory behind
+	if err := store.Delete("refs/heads/packed/deep", repo.commit); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Read("refs/heads/packed/deep"); !isRefNotFound(err) {
+		t.Errorf("deleted ref still reads as %v", err)
+	}
+	if dirs := refDirectories(t, store); !reflect.DeepEqual(dirs, initialDirs) {
+		t.Errorf("directories after delete = %v, want %v", dirs, initialDirs)
+	}
+
+	// A failed check changes nothing, not even packed-refs
+	before, err := os.ReadFile(store.packedRefsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := store.Begin()
+	tx.Create("refs/heads/new/branch", repo.commit, false)
+	tx.Delete("refs/heads/other", "", false)
+	tx.Verify("refs/heads/other", zeroSHA, true)
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("transaction with a duplicate ref succeeded")
+	}
+	tx = store.Begin()
+	tx.Create("refs/heads/new/branch", repo.commit, false)
+	tx.Delete("refs/heads/other", repo.tag, false)
+	if err := tx.Commit(); err == nil || !strings.Contains(err.Error(), "but expected") {
+		t.Fatalf("transaction with a wrong old value = %v, want a mismatch", err)
+	}
+	after, err := os.ReadFile(store.packedRefsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("failed transaction rewrote packed-refs")
+	}
+	if _, err := store.Read("refs/heads/new/branch"); !isRefNotFound(err) {
+		t.Errorf("failed transaction created a ref: %v", err)
+	}
+	if dirs := refDirectories(t, store); !reflect.DeepEqual(dirs, initialDirs) {
+		t.Errorf("directories after failed transaction = %v, want %v", dirs, initialDirs)
+	}
+	if locks, _ := filepath.Glob(filepath.Join(store.Dir, "*.lock")); len(locks) > 0 {
+		t.Errorf("locks left behind: %v", locks)
+	}
+
+	// The same transaction with the right values applies every update
+	tx = store.Begin()
+	tx.Create("refs/heads/new/branch", repo.commit, false)
+	tx.Delete("refs/heads/other", repo.commit, false)
+	tx.Update("refs/tags/v1", repo.tag, zeroSHA, false)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	refs, err := store.List("refs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Name+" "+ref.SHA)
+	}
+	want := []string{"refs/heads/new/branch " + repo.commit, "refs/tags/v1 " + repo.tag}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("refs = %v, want %v", names, want)
+	}
+
+	// Branches only point at commits
+	if err := store.Update("refs/heads/tagged", repo.tag, ""); err == nil {
+		t.Errorf("branch pointed at a tag object")
+	}
+}
+
+func TestRefStorePack(t *testing.T) {
+	repo := newTestRepository(t)
+	store := repo.store
+
+	if err := store.Update("refs/heads/main", repo.commit, zeroSHA); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update("refs/tags/v2", repo.nested, zeroSHA); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteSymbolic("refs/remotes/origin/HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Pack(); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	data, err := os.ReadFile(store.packedRefsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# pack-refs with: peeled fully-peeled sorted \n" +
+		repo.commit + " refs/heads/main\n" +
+		repo.nested + " refs/tags/v2\n" +
+		"^" + repo.commit + "\n"
+	if string(data) != want {
+		t.Errorf("packed-refs is\n%s\nwant\n%s", data, want)
+	}
+
+	for _, name := range []string{"refs/heads/main", "refs/tags/v2"} {
+		if _, err := os.Stat(store.path(name)); err == nil {
+			t.Errorf("loose %s not removed", name)
+		}
+	}
+	if target, err := store.ReadSymbolic("refs/remotes/origin/HEAD"); err != nil || target != "refs/heads/main" {
+		t.Errorf("symbolic ref = %q, %v; want it kept loose", target, err)
+	}
+	if sha, err := store.Resolve("refs/remotes/origin/HEAD"); err != nil || sha != repo.commit {
+		t.Errorf("symbolic ref resolves to %q, %v; want %s", sha, err, repo.commit)
+	}
+}
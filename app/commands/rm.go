@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RmCommand struct{}
+
+func (c *RmCommand) GetName() string {
+	return "rm"
+}
+
+func (c *RmCommand) Execute(cmd *Command) error {
+	// Format: rm [--cached] [-f] [-r] [-q] [--] <pathspec>...
+	var cached, force, recursive, quiet bool
+	var pathspecs []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			pathspecs = append(pathspecs, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "--cached":
+			cached = true
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "-r":
+			recursive = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			pathspecs = append(pathspecs, arg)
+		}
+	}
+
+	if len(pathspecs) == 0 {
+		return fmt.Errorf("usage: rm [--cached] [-f] [-r] [-q] [--] <pathspec>...")
+	}
+
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Check every pathspec before touching anything
+	var paths []string
+	for _, arg := range pathspecs {
+		pathspec, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+
+		if pathspec != "" && index.Has(pathspec) {
+			paths = append(paths, pathspec)
+			continue
+		}
+
+		var below []string
+		for _, entry := range index.Entries {
+			if matchesPathspec(entry.Path, pathspec) && (len(below) == 0 || below[len(below)-1] != entry.Path) {
+				below = append(below, entry.Path)
+			}
+		}
+		if len(below) == 0 {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+		if !recursive {
+			return fmt.Errorf("not removing '%s' recursively without -r", arg)
+		}
+		paths = append(paths, below...)
+	}
+
+	if !force {
+		if err := checkRemovable(index, paths, cached); err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	for _, path := range paths {
+		if !index.Remove(path) {
+			continue
+		}
+		removed = append(removed, path)
+		if !quiet {
+			fmt.Printf("rm '%s'\n", path)
+		}
+	}
+
+	// The index is written first, so a failure leaves the files in place
+	if err := index.Write(indexPath); err != nil {
+		return err
+	}
+	if cached {
+		return nil
+	}
+
+	for _, path := range removed {
+		if err := os.Remove(filepath.FromSlash(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error removing '%s': %w", path, err)
+		}
+		removeEmptyParents(filepath.Dir(filepath.FromSlash(path)))
+	}
+	return nil
+}
+
+// checkRemovable refuses to remove paths whose content would be lost: a
+// staged version that matches neither HEAD nor the file, or, when the
+// file itself goes too, changes staged or made in the worktree. Conflicted
+// paths and files already gone from the worktree are always removable
+func checkRemovable(index *Index, paths []string, cached bool) error {
+	_, headFiles, err := readHeadFiles()
+	if err != nil {
+		return err
+	}
+
+	var both, staged, local []string
+	for _, path := range paths {
+		entry := index.Find(path)
+		if entry == nil {
+			continue
+		}
+		info, err := os.Lstat(filepath.FromSlash(path))
+		if err != nil || (info.IsDir() && entry.Mode != 0160000) {
+			continue
+		}
+
+		code, _, _, err := worktreeChange(index, entry)
+		if err != nil {
+			return err
+		}
+		localChanges := code != ' '
+		head, inHead := headFiles[path]
+		stagedChanges := !inHead || head.sha != entry.SHA || head.mode != entry.Mode
+
+		switch {
+		case localChanges && stagedChanges:
+			if !cached || entry.ExtendedFlags&indexFlagIntentToAdd == 0 {
+				both = append(both, path)
+			}
+		case cached:
+		case stagedChanges:
+			staged = append(staged, path)
+		case localChanges:
+			local = append(local, path)
+		}
+	}
+
+	var problems []string
+	report := func(files []string, what, hint string) {
+		if len(files) == 0 {
+			return
+		}
+		problem := "the following file has " + what
+		if len(files) > 1 {
+			problem = "the following files have " + what
+		}
+		for _, file := range files {
+			problem += "\n    " + file
+		}
+		problems = append(problems, problem+"\n"+hint)
+	}
+	report(both, "staged content different from both the\nfile and the HEAD:", "(use -f to force removal)")
+	report(staged, "changes staged in the index:", "(use --cached to keep the file, or -f to force removal)")
+	report(local, "local modifications:", "(use --cached to keep the file, or -f to force removal)")
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// removeEmptyParents removes directories left empty by a removal, up to the top
+func removeEmptyParents(dir string) {
+	for dir != "." && dir != string(filepath.Separator) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
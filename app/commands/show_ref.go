@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ShowRefCommand struct{}
+
+func (c *ShowRefCommand) GetName() string {
+	return "show-ref"
+}
+
+func (c *ShowRefCommand) Execute(cmd *Command) error {
+	// Format: show-ref [--head] [--heads] [--tags] [-d|--dereference]
+	//         [-s|--hash[=<n>]] [-q|--quiet] [--verify] [--] [<pattern>...]
+	var head, heads, tags, dereference, hashOnly, quiet, verify bool
+	abbrev := 40
+	var patterns []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			patterns = append(patterns, cmd.Args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "--head":
+			head = true
+		case "--heads":
+			heads = true
+		case "--tags":
+			tags = true
+		case "-d", "--dereference":
+			dereference = true
+		case "-s", "--hash":
+			hashOnly = true
+		case "-q", "--quiet":
+			quiet = true
+		case "--verify":
+			verify = true
+		default:
+			if value, found := strings.CutPrefix(arg, "--hash="); found {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 4 || n > 40 {
+					return fmt.Errorf("invalid --hash length: %s", value)
+				}
+				hashOnly, abbrev = true, n
+			} else if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			} else {
+				patterns = append(patterns, arg)
+			}
+		}
+	}
+
+	show := func(ref *Ref) {
+		if quiet {
+			return
+		}
+		if hashOnly {
+			fmt.Println(ref.SHA[:abbrev])
+		} else {
+			fmt.Printf("%s %s\n", ref.SHA[:abbrev], ref.Name)
+		}
+		if !dereference {
+			return
+		}
+		peeled := ref.Peeled
+		if peeled == "" {
+			peeled, _, _ = peelObject(ref.SHA)
+		}
+		if peeled != "" && peeled != ref.SHA {
+			if hashOnly {
+				fmt.Println(peeled[:abbrev])
+			} else {
+				fmt.Printf("%s %s^{}\n", peeled[:abbrev], ref.Name)
+			}
+		}
+	}
+
+	// --verify takes full ref names, each of which must exist
+	if verify {
+		if len(patterns) == 0 {
+			return fmt.Errorf("--verify requires a reference")
+		}
+		for _, name := range patterns {
+			var sha string
+			var err error
+			if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+				sha, err = refStore.Resolve(name)
+			}
+			if name != "HEAD" && !strings.HasPrefix(name, "refs/") || err != nil {
+				if quiet {
+					return ErrExitStatus
+				}
+				return fmt.Errorf("'%s' - not a valid ref", name)
+			}
+			show(&Ref{Name: name, SHA: sha})
+		}
+		return nil
+	}
+
+	refs, err := refStore.List("refs/")
+	if err != nil {
+		return err
+	}
+	if head {
+		if sha, err := refStore.Resolve("HEAD"); err == nil {
+			refs = append([]*Ref{{Name: "HEAD", SHA: sha}}, refs...)
+		}
+	}
+
+	found := false
+	for _, ref := range refs {
+		// HEAD is shown whenever --head asks for it
+		if ref.Name != "HEAD" {
+			if (heads || tags) && !(heads && strings.HasPrefix(ref.Name, "refs/heads/")) && !(tags && strings.HasPrefix(ref.Name, "refs/tags/")) {
+				continue
+			}
+			if !showRefMatch(ref.Name, patterns) {
+				continue
+			}
+		}
+		found = true
+		show(ref)
+	}
+	if !found {
+		return ErrExitStatus
+	}
+	return nil
+}
+
+// showRefMatch reports whether a ref matches one of the patterns, which
+// match whole trailing components: "main" matches refs/heads/main and
+// refs/remotes/origin/main but not refs/heads/domain
+func showRefMatch(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+	return false
+}
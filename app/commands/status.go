@@ -0,0 +1,602 @@
+package commands
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type StatusCommand struct{}
+
+func (c *StatusCommand) GetName() string {
+	return "status"
+}
+
+// statusFormat selects how status is printed
+type statusFormat int
+
+const (
+	statusLong statusFormat = iota
+	statusShort
+	statusPorcelainV1
+	statusPorcelainV2
+)
+
+// statusEntry is a tracked path that differs between HEAD, the index
+// and the worktree. Change codes are those of git status --short
+type statusEntry struct {
+	path     string
+	staged   byte // HEAD to index: ' ', 'A', 'M', 'D', 'T', or a conflict code
+	unstaged byte // index to worktree: ' ', 'M', 'D', 'T', or a conflict code
+
+	headMode, indexMode, worktreeMode uint32
+	headSHA, indexSHA                 [20]byte
+
+	stages [3]*IndexEntry // stages 1 to 3 of an unmerged path
+}
+
+func (e *statusEntry) unmerged() bool {
+	return e.stages != [3]*IndexEntry{}
+}
+
+// repoStatus is everything status reports
+type repoStatus struct {
+	branch    string // "" when HEAD is detached
+	head      string // "" before the first commit
+	entries   []*statusEntry
+	untracked []string
+}
+
+// treeFile is a file of a flattened tree
+type treeFile struct {
+	mode uint32
+	sha  [20]byte
+}
+
+func (c *StatusCommand) Execute(cmd *Command) error {
+	// Format: status [-s | --short | --porcelain[=v1|v2]] [-b | --branch] [-z]
+	format := statusLong
+	formatSet, showBranch := false, false
+	terminator := "\n"
+
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "-s", "--short":
+			format, formatSet = statusShort, true
+		case "--porcelain", "--porcelain=v1":
+			format, formatSet = statusPorcelainV1, true
+		case "--porcelain=v2":
+			format, formatSet = statusPorcelainV2, true
+		case "--long":
+			format, formatSet = statusLong, true
+		case "-b", "--branch":
+			showBranch = true
+		case "-z":
+			terminator = "\x00"
+		default:
+			return fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	// -z alone implies the porcelain format
+	if terminator == "\x00" && !formatSet {
+		format = statusPorcelainV1
+	}
+
+	status, err := collectStatus()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case statusShort, statusPorcelainV1:
+		printShortStatus(status, showBranch, terminator)
+	case statusPorcelainV2:
+		printPorcelainV2Status(status, showBranch, terminator)
+	default:
+		printLongStatus(status)
+	}
+	return nil
+}
+
+// collectStatus compares HEAD to the index and the index to the worktree
+func collectStatus() (*repoStatus, error) {
+	status := &repoStatus{}
+
+	target, err := refStore.ReadSymbolic("HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("error reading HEAD: %w", err)
+	}
+	status.branch = strings.TrimPrefix(target, "refs/heads/")
+
+	head, headFiles, err := readHeadFiles()
+	if err != nil {
+		return nil, err
+	}
+	status.head = head
+
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := false
+	inIndex := make(map[string]bool)
+	for i := 0; i < len(index.Entries); {
+		entry := index.Entries[i]
+		inIndex[entry.Path] = true
+
+		end := i
+		for end < len(index.Entries) && index.Entries[end].Path == entry.Path {
+			end++
+		}
+		head, inHead := headFiles[entry.Path]
+
+		if entry.Stage() != 0 {
+			change := &statusEntry{path: entry.Path, headMode: head.mode, headSHA: head.sha}
+			for _, stage := range index.Entries[i:end] {
+				change.stages[stage.Stage()-1] = stage
+			}
+			change.staged, change.unstaged = conflictCodes(change.stages)
+			if info, err := os.Lstat(filepath.FromSlash(entry.Path)); err == nil {
+				change.worktreeMode = indexMode(info)
+			}
+			status.entries = append(status.entries, change)
+			i = end
+			continue
+		}
+		i = end
+
+		change := &statusEntry{
+			path:      entry.Path,
+			staged:    ' ',
+			headMode:  head.mode,
+			headSHA:   head.sha,
+			indexMode: entry.Mode,
+			indexSHA:  entry.SHA,
+		}
+		if !inHead {
+			change.staged = 'A'
+		} else if head.sha != entry.SHA || head.mode != entry.Mode {
+			change.staged = changeCode(head.mode, entry.Mode)
+		}
+
+		var refresh bool
+		change.unstaged, change.worktreeMode, refresh, err = worktreeChange(index, entry)
+		if err != nil {
+			return nil, err
+		}
+		refreshed = refreshed || refresh
+
+		if change.staged != ' ' || change.unstaged != ' ' {
+			status.entries = append(status.entries, change)
+		}
+	}
+
+	for path, head := range headFiles {
+		if !inIndex[path] {
+			status.entries = append(status.entries, &statusEntry{
+				path:     path,
+				staged:   'D',
+				unstaged: ' ',
+				headMode: head.mode,
+				headSHA:  head.sha,
+			})
+		}
+	}
+	sort.Slice(status.entries, func(i, j int) bool {
+		return status.entries[i].path < status.entries[j].path
+	})
+
+	status.untracked, err = untrackedFiles(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the stat data of files that were only touched, so they are
+	// not hashed again next time. Another process holding the lock is fine
+	if refreshed {
+		index.Write(indexPath)
+	}
+
+	return status, nil
+}
+
+// readHeadFiles returns the commit HEAD points at and its files, keyed by
+// path. On an unborn branch both are empty
+func readHeadFiles() (string, map[string]treeFile, error) {
+	files := make(map[string]treeFile)
+	head, err := ResolveRevision("HEAD")
+	if err != nil {
+		return "", files, nil
+	}
+
+	_, content, err := ReadObject(head)
+	if err != nil {
+		return "", nil, err
+	}
+	commit, err := ParseCommit(content)
+	if err != nil {
+		return "", nil, fmt.Errorf("error parsing commit %s: %w", head, err)
+	}
+	if err := flattenTree(commit.Tree, "", files); err != nil {
+		return "", nil, err
+	}
+	return head, files, nil
+}
+
+// worktreeChange compares an index entry with the file in the worktree,
+// hashing it only when its stat data changed. refresh reports that the
+// entry's stat data was updated because the content turned out the same
+func worktreeChange(index *Index, entry *IndexEntry) (code byte, mode uint32, refresh bool, err error) {
+	path := filepath.FromSlash(entry.Path)
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return 'D', 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("error reading %s: %w", entry.Path, err)
+	}
+
+	// A gitlink is only compared by the commit its repository has checked
+	// out; an empty directory is a submodule that was never cloned
+	if entry.Mode == 0160000 {
+		if !info.IsDir() {
+			return changeCode(entry.Mode, indexMode(info)), indexMode(info), false, nil
+		}
+		if head, err := gitlinkHead(path); err == nil && head != hex.EncodeToString(entry.SHA[:]) {
+			return 'M', entry.Mode, false, nil
+		}
+		return ' ', entry.Mode, false, nil
+	}
+	if info.IsDir() && !isNestedRepository(path) {
+		return 'D', 0, false, nil
+	}
+
+	mode = indexMode(info)
+	if index.StatMatches(entry, info) {
+		return ' ', mode, false, nil
+	}
+
+	sha, err := hashWorktreeEntry(path, info, false)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	if sha == entry.SHA && mode == entry.Mode {
+		fillStatData(entry, info)
+		entry.Size = uint32(info.Size())
+		return ' ', mode, true, nil
+	}
+	return changeCode(entry.Mode, mode), mode, false, nil
+}
+
+// changeCode is 'T' when a path changed type, say from file to symlink,
+// and 'M' otherwise
+func changeCode(oldMode, newMode uint32) byte {
+	if oldMode&0170000 != newMode&0170000 {
+		return 'T'
+	}
+	return 'M'
+}
+
+// conflictCodes returns the short status of an unmerged path from the
+// stages present: 1 is the common base, 2 ours and 3 theirs
+func conflictCodes(stages [3]*IndexEntry) (byte, byte) {
+	base, ours, theirs := stages[0] != nil, stages[1] != nil, stages[2] != nil
+	switch {
+	case base && ours && theirs:
+		return 'U', 'U'
+	case ours && theirs:
+		return 'A', 'A'
+	case base && ours:
+		return 'U', 'D'
+	case base && theirs:
+		return 'D', 'U'
+	case ours:
+		return 'A', 'U'
+	case theirs:
+		return 'U', 'A'
+	default:
+		return 'D', 'D'
+	}
+}
+
+// flattenTree adds every file below a tree to files, keyed by path
+func flattenTree(treeSHA, prefix string, files map[string]treeFile) error {
+	tree, err := ReadTree(treeSHA)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range tree.Entries {
+		path := prefix + entry.Name
+		if entry.Mode == "40000" {
+			if err := flattenTree(hex.EncodeToString(entry.SHA), path+"/", files); err != nil {
+				return err
+			}
+			continue
+		}
+
+		mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode %q in tree %s", entry.Mode, treeSHA)
+		}
+		file := treeFile{mode: uint32(mode)}
+		copy(file.sha[:], entry.SHA)
+		files[path] = file
+	}
+	return nil
+}
+
+// untrackedFiles lists worktree files that are neither in the index nor
+// ignored. A directory holding no tracked file is listed once, as "dir/"
+func untrackedFiles(index *Index) ([]string, error) {
+	ignore, err := NewIgnoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool)
+	trackedDirs := make(map[string]bool)
+	for _, entry := range index.Entries {
+		tracked[entry.Path] = true
+		for dir := entry.Path; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			trackedDirs[dir] = true
+		}
+	}
+
+	var untracked []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		files, err := os.ReadDir(filepath.FromSlash(dir))
+		if err != nil {
+			return fmt.Errorf("error reading directory %s: %w", dir, err)
+		}
+
+		for _, file := range files {
+			if file.Name() == ".git" {
+				continue
+			}
+			path := file.Name()
+			if dir != "." {
+				path = dir + "/" + file.Name()
+			}
+
+			switch {
+			case tracked[path]:
+				// A tracked directory is a gitlink
+			case !file.IsDir():
+				if !tracked[path] && !ignore.Ignored(path, false) {
+					untracked = append(untracked, path)
+				}
+			case trackedDirs[path]:
+				if err := walk(path); err != nil {
+					return err
+				}
+			case !ignore.Ignored(path, true) && containsUntracked(path, ignore):
+				untracked = append(untracked, path+"/")
+			}
+		}
+		return nil
+	}
+
+	if err := walk("."); err != nil {
+		return nil, err
+	}
+	sort.Strings(untracked)
+	return untracked, nil
+}
+
+// containsUntracked reports whether there is a file below the untracked
+// directory that is not ignored
+func containsUntracked(dir string, ignore *IgnoreMatcher) bool {
+	found := errors.New("found")
+	err := filepath.WalkDir(filepath.FromSlash(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(path)
+		if d.IsDir() {
+			if name != dir && ignore.Ignored(name, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !ignore.Ignored(name, false) {
+			return found
+		}
+		return nil
+	})
+	return err == found
+}
+
+// printLongStatus prints the human readable status
+func printLongStatus(status *repoStatus) {
+	if status.branch != "" {
+		fmt.Printf("On branch %s\n", status.branch)
+	} else {
+		fmt.Printf("HEAD detached at %s\n", status.head[:7])
+	}
+
+	var staged, unmerged, unstaged []string
+	for _, entry := range status.entries {
+		if entry.unmerged() {
+			unmerged = append(unmerged, fmt.Sprintf("\t%-17s%s", conflictLabel(entry.staged, entry.unstaged), entry.path))
+			continue
+		}
+		if entry.staged != ' ' {
+			staged = append(staged, fmt.Sprintf("\t%-12s%s", changeLabel(entry.staged), entry.path))
+		}
+		if entry.unstaged != ' ' {
+			unstaged = append(unstaged, fmt.Sprintf("\t%-12s%s", changeLabel(entry.unstaged), entry.path))
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(".git", "MERGE_HEAD")); err == nil {
+		if len(unmerged) > 0 {
+			fmt.Printf("You have unmerged paths.\n\n")
+		} else {
+			fmt.Printf("All conflicts fixed but you are still merging.\n\n")
+		}
+	}
+	if status.head == "" {
+		fmt.Printf("\nNo commits yet\n\n")
+	}
+
+	for _, section := range []struct {
+		title string
+		lines []string
+	}{
+		{"Changes to be committed:", staged},
+		{"Unmerged paths:", unmerged},
+		{"Changes not staged for commit:", unstaged},
+		{"Untracked files:", prefixLines("\t", status.untracked)},
+	} {
+		if len(section.lines) == 0 {
+			continue
+		}
+		fmt.Println(section.title)
+		for _, line := range section.lines {
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0 || len(unmerged) > 0:
+		fmt.Println("no changes added to commit")
+	case len(status.untracked) > 0:
+		fmt.Println("nothing added to commit but untracked files present")
+	case status.head == "":
+		fmt.Println("nothing to commit")
+	default:
+		fmt.Println("nothing to commit, working tree clean")
+	}
+}
+
+// changeLabel describes a change code in the long format
+func changeLabel(code byte) string {
+	switch code {
+	case 'A':
+		return "new file:"
+	case 'D':
+		return "deleted:"
+	case 'T':
+		return "typechange:"
+	default:
+		return "modified:"
+	}
+}
+
+// conflictLabel describes the conflict codes of an unmerged path
+func conflictLabel(staged, unstaged byte) string {
+	switch string([]byte{staged, unstaged}) {
+	case "AA":
+		return "both added:"
+	case "UD":
+		return "deleted by them:"
+	case "DU":
+		return "deleted by us:"
+	case "AU":
+		return "added by us:"
+	case "UA":
+		return "added by them:"
+	case "DD":
+		return "both deleted:"
+	default:
+		return "both modified:"
+	}
+}
+
+func prefixLines(prefix string, lines []string) []string {
+	prefixed := make([]string, len(lines))
+	for i, line := range lines {
+		prefixed[i] = prefix + line
+	}
+	return prefixed
+}
+
+// printShortStatus prints "XY path" lines, as --short and --porcelain=v1 do
+func printShortStatus(status *repoStatus, showBranch bool, terminator string) {
+	if showBranch {
+		switch {
+		case status.branch == "":
+			fmt.Printf("## HEAD (no branch)%s", terminator)
+		case status.head == "":
+			fmt.Printf("## No commits yet on %s%s", status.branch, terminator)
+		default:
+			fmt.Printf("## %s%s", status.branch, terminator)
+		}
+	}
+
+	for _, entry := range status.entries {
+		fmt.Printf("%c%c %s%s", entry.staged, entry.unstaged, entry.path, terminator)
+	}
+	for _, path := range status.untracked {
+		fmt.Printf("?? %s%s", path, terminator)
+	}
+}
+
+// printPorcelainV2Status prints the --porcelain=v2 format, which carries
+// the modes and SHAs of each changed path
+func printPorcelainV2Status(status *repoStatus, showBranch bool, terminator string) {
+	if showBranch {
+		oid, head := status.head, status.branch
+		if oid == "" {
+			oid = "(initial)"
+		}
+		if head == "" {
+			head = "(detached)"
+		}
+		fmt.Printf("# branch.oid %s%s# branch.head %s%s", oid, terminator, head, terminator)
+	}
+
+	xy := func(entry *statusEntry) string {
+		return strings.ReplaceAll(string([]byte{entry.staged, entry.unstaged}), " ", ".")
+	}
+	submodule := func(entry *statusEntry) string {
+		if entry.indexMode != 0160000 && entry.headMode != 0160000 {
+			return "N..."
+		}
+		if entry.unstaged == 'M' {
+			return "SC.."
+		}
+		return "S..."
+	}
+
+	for _, entry := range status.entries {
+		if !entry.unmerged() {
+			// 1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+			fmt.Printf("1 %s %s %06o %06o %06o %x %x %s%s", xy(entry), submodule(entry),
+				entry.headMode, entry.indexMode, entry.worktreeMode,
+				entry.headSHA, entry.indexSHA, entry.path, terminator)
+			continue
+		}
+
+		// u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+		var modes [3]uint32
+		var shas [3][20]byte
+		for i, stage := range entry.stages {
+			if stage != nil {
+				modes[i], shas[i] = stage.Mode, stage.SHA
+			}
+		}
+		fmt.Printf("u %s N... %06o %06o %06o %06o %x %x %x %s%s", xy(entry),
+			modes[0], modes[1], modes[2], entry.worktreeMode,
+			shas[0], shas[1], shas[2], entry.path, terminator)
+	}
+
+	for _, path := range status.untracked {
+		fmt.Printf("? %s%s", path, terminator)
+	}
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SymbolicRefCommand struct{}
+
+func (c *SymbolicRefCommand) GetName() string {
+	return "symbolic-ref"
+}
+
+func (c *SymbolicRefCommand) Execute(cmd *Command) error {
+	// Format: symbolic-ref [-q] [--short] <name>
+	//         symbolic-ref [-m <reason>] <name> <ref>
+	//         symbolic-ref (-d|--delete) [-q] <name>
+	var quiet, short, remove bool
+	var positional []string
+
+	for i := 0; i < len(cmd.Args); i++ {
+		switch arg := cmd.Args[i]; arg {
+		case "-q", "--quiet":
+			quiet = true
+		case "--short":
+			short = true
+		case "-d", "--delete":
+			remove = true
+		case "-m":
+			// There are no reflogs to record the reason in
+			if i+1 == len(cmd.Args) {
+				return fmt.Errorf("option '-m' requires a value")
+			}
+			i++
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	switch {
+	case remove && len(positional) == 1:
+		name := positional[0]
+		if name == "HEAD" {
+			return fmt.Errorf("deleting '%s' is not allowed", name)
+		}
+		if err := refStore.DeleteSymbolic(name); err != nil {
+			if quiet {
+				return ErrExitStatus
+			}
+			return fmt.Errorf("cannot delete %s: %w", name, err)
+		}
+		return nil
+
+	case !remove && len(positional) == 1:
+		name := positional[0]
+		target, err := refStore.ReadSymbolic(name)
+		if err != nil || target == "" {
+			if quiet {
+				return ErrExitStatus
+			}
+			return fmt.Errorf("ref %s is not a symbolic ref", name)
+		}
+		// Chains of symbolic refs are followed to the end
+		final, err := refStore.Follow(name)
+		if err != nil {
+			return err
+		}
+		if short {
+			final = shortenRefName(final)
+		}
+		fmt.Println(final)
+		return nil
+
+	case !remove && len(positional) == 2:
+		name, target := positional[0], positional[1]
+		if name == "HEAD" && !strings.HasPrefix(target, "refs/") {
+			return fmt.Errorf("refusing to point HEAD outside of refs/")
+		}
+		if !validRefName(target) {
+			return fmt.Errorf("refusing to set '%s' to invalid ref '%s'", name, target)
+		}
+		return refStore.WriteSymbolic(name, target)
+	}
+
+	return fmt.Errorf("usage: symbolic-ref [-q] [--short] [-d] <name> [<ref>]")
+}
+
+// shortenRefName drops the namespace prefix git leaves out when showing a
+// ref: refs/heads/, refs/tags/, refs/remotes/ or refs/
+func shortenRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, found := strings.CutPrefix(name, prefix); found {
+			return short
+		}
+	}
+	return name
+}
@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Tag is an annotated tag object
+type Tag struct {
+	Object  string
+	Type    GitObjectType
+	Name    string
+	Tagger  string
+	Message string
+}
+
+// ParseTag parses the content of a tag object
+func ParseTag(content []byte) (*Tag, error) {
+	tag := &Tag{}
+
+	// Headers run until the first blank line, the rest is the message
+	headers, message, found := bytes.Cut(content, []byte("\n\n"))
+	if !found {
+		headers = bytes.TrimSuffix(content, []byte("\n"))
+	}
+	tag.Message = string(message)
+
+	for _, line := range strings.Split(string(headers), "\n") {
+		key, value, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("invalid tag header: %q", line)
+		}
+
+		switch key {
+		case "object":
+			if !isObjectSHA(value) {
+				return nil, fmt.Errorf("invalid tag object: %q", value)
+			}
+			tag.Object = value
+		case "type":
+			tag.Type = GitObjectType(value)
+		case "tag":
+			tag.Name = value
+		case "tagger":
+			tag.Tagger = value
+		}
+	}
+
+	if tag.Object == "" || tag.Type == "" || tag.Name == "" {
+		return nil, fmt.Errorf("invalid tag: missing object, type or tag header")
+	}
+
+	return tag, nil
+}
+
+// Serialize encodes the tag in the format it is stored in
+func (t *Tag) Serialize() []byte {
+	/*
+		Tag format:
+		object <sha>
+		type <type>
+		tag <name>
+		tagger <name> <email> <timestamp> <timezone>  (optional)
+
+		<tag message>
+	*/
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "object %s\n", t.Object)
+	fmt.Fprintf(&content, "type %s\n", t.Type)
+	fmt.Fprintf(&content, "tag %s\n", t.Name)
+	if t.Tagger != "" {
+		fmt.Fprintf(&content, "tagger %s\n", t.Tagger)
+	}
+	fmt.Fprintf(&content, "\n%s", t.Message)
+	return content.Bytes()
+}
+
+type TagCommand struct{}
+
+func (c *TagCommand) GetName() string {
+	return "tag"
+}
+
+func (c *TagCommand) Execute(cmd *Command) error {
+	// Format: tag                                   (list tags)
+	//         tag [-f] <name> [<object>]            (lightweight tag)
+	//         tag [-f] -a <name> -m <msg> [<object>] (annotated tag)
+	//         tag -d <name>
+	var annotate, force, remove bool
+	var message string
+	var hasMessage bool
+	var positional []string
+
+	for i := 0; i < len(cmd.Args); i++ {
+		switch arg := cmd.Args[i]; arg {
+		case "-a":
+			annotate = true
+		case "-f":
+			force = true
+		case "-d":
+			remove = true
+		case "-l":
+			// Listing is the default without a name
+		case "-m":
+			if i+1 >= len(cmd.Args) {
+				return fmt.Errorf("option -m requires a value")
+			}
+			message = cmd.Args[i+1]
+			hasMessage = true
+			i++
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 {
+		return listTags()
+	}
+	if len(positional) > 2 {
+		return fmt.Errorf("usage: tag [-a] [-f] [-m <msg>] <tagname> [<object>]")
+	}
+
+	name := positional[0]
+	ref := "refs/tags/" + name
+
+	if remove {
+		if err := refStore.Delete(ref, ""); isRefNotFound(err) {
+			return fmt.Errorf("tag '%s' not found", name)
+		} else if err != nil {
+			return fmt.Errorf("error deleting tag '%s': %w", name, err)
+		}
+		return nil
+	}
+
+	if !validRefName(ref) {
+		return fmt.Errorf("'%s' is not a valid tag name", name)
+	}
+	if _, err := refStore.Read(ref); err == nil && !force {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+
+	revision := "HEAD"
+	if len(positional) == 2 {
+		revision = positional[1]
+	}
+	target, err := ResolveRevision(revision)
+	if err != nil {
+		return err
+	}
+
+	// -m implies an annotated tag, as in git
+	if annotate || hasMessage {
+		if !hasMessage {
+			return fmt.Errorf("annotated tag %s needs a message (-m)", name)
+		}
+
+		targetData, err := ReadGitObject(target)
+		if err != nil {
+			return fmt.Errorf("error reading tag target %s: %w", target, err)
+		}
+		targetType, _, err := ParseGitObject(targetData)
+		if err != nil {
+			return fmt.Errorf("error parsing tag target %s: %w", target, err)
+		}
+
+		tagger, err := committerIdentity(loadConfigOrWarn())
+		if err != nil {
+			return err
+		}
+		tag := &Tag{
+			Object:  target,
+			Type:    targetType,
+			Name:    name,
+			Tagger:  tagger.String(),
+			Message: strings.TrimRight(message, "\n") + "\n",
+		}
+		target = string(WriteGitObject(TagObject, tag.Serialize(), true))
+	}
+
+	return refStore.Update(ref, target, "")
+}
+
+// listTags prints the names of all tags in sorted order
+func listTags() error {
+	refs, err := refStore.List("refs/tags/")
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		fmt.Println(strings.TrimPrefix(ref.Name, "refs/tags/"))
+	}
+	return nil
+}
+
+// validRefName applies the basic rules from git check-ref-format
+func validRefName(ref string) bool {
+	if strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".lock") || strings.HasSuffix(ref, ".") {
+		return false
+	}
+	if strings.Contains(ref, "..") || strings.Contains(ref, "@{") || strings.Contains(ref, "//") {
+		return false
+	}
+	for _, component := range strings.Split(ref, "/") {
+		if component == "" || strings.HasPrefix(component, ".") {
+			return false
+		}
+	}
+	for _, r := range ref {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TreeEntry is one entry of a tree object
+type TreeEntry struct {
+	Mode string // octal, without leading zeros: "100644", "40000", ...
+	Name string
+	SHA  []byte
+}
+
+// Tree is the parsed content of a tree object
+type Tree struct {
+	Entries []TreeEntry // in git's order, see treeEntryLess
+}
+
+// ReadTree reads a tree object and checks that it is canonical
+func ReadTree(sha string) (*Tree, error) {
+	objectType, content, err := ReadObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if objectType != TreeObject {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", sha, objectType)
+	}
+	tree, err := DecodeTree(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tree %s: %w", sha, err)
+	}
+	return tree, nil
+}
+
+// DecodeTree parses the content of a tree object. Trees git would not
+// have written are rejected, since the same entries encoded another way
+// would hash to a different object
+func DecodeTree(content []byte) (*Tree, error) {
+	entries, err := parseTreeEntries(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		if entry.Mode == "" || entry.Mode[0] == '0' || strings.Trim(entry.Mode, "01234567") != "" {
+			return nil, fmt.Errorf("invalid mode %q for %q", entry.Mode, entry.Name)
+		}
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." || strings.Contains(entry.Name, "/") {
+			return nil, fmt.Errorf("invalid entry name %q", entry.Name)
+		}
+		if isDotGitName(entry.Name) {
+			return nil, fmt.Errorf("invalid entry name %q: checking it out would overwrite the repository", entry.Name)
+		}
+		if i > 0 && !treeEntryLess(entries[i-1], entry) {
+			return nil, fmt.Errorf("entries not sorted at %q", entry.Name)
+		}
+	}
+
+	return &Tree{Entries: entries}, nil
+}
+
+// Encode serializes the tree, sorting its entries first
+func (t *Tree) Encode() []byte {
+	sort.SliceStable(t.Entries, func(i, j int) bool {
+		return treeEntryLess(t.Entries[i], t.Entries[j])
+	})
+
+	var buf bytes.Buffer
+	for _, entry := range t.Entries {
+		// Format: <mode> <name>\0<20_byte_sha>
+		fmt.Fprintf(&buf, "%s %s\x00", entry.Mode, entry.Name)
+		buf.Write(entry.SHA)
+	}
+	return buf.Bytes()
+}
+
+// Write stores the tree object and returns its SHA in hex
+func (t *Tree) Write() string {
+	return string(WriteGitObject(TreeObject, t.Encode(), true))
+}
+
+// isDotGitName reports whether a tree entry name would be taken as the
+// repository directory itself on some filesystem: ".git" in any case,
+// with the trailing dots and spaces Windows drops, or its "git~1" short name
+func isDotGitName(name string) bool {
+	name = strings.ToLower(strings.TrimRight(name, ". "))
+	return name == ".git" || name == "git~1"
+}
+
+// treeEntryLess orders tree entries the way git does: a directory sorts
+// as if its name ended in "/", so "foo.c" comes before the directory "foo"
+func treeEntryLess(a, b TreeEntry) bool {
+	nameA, nameB := a.Name, b.Name
+	if a.Mode == "40000" {
+		nameA += "/"
+	}
+	if b.Mode == "40000" {
+		nameB += "/"
+	}
+	return nameA < nameB
+}
+
+// parseTreeEntries splits the content of a tree object into its entries
+// without checking them, for callers such as fsck that report problems
+// themselves. Tree format: [<mode> <name>\0<20_byte_sha>]*
+func parseTreeEntries(content []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+
+	for len(content) > 0 {
+		spaceIndex := bytes.IndexByte(content, ' ')
+		if spaceIndex == -1 {
+			return nil, fmt.Errorf("invalid tree entry: missing mode")
+		}
+		nullIndex := bytes.IndexByte(content, 0)
+		if nullIndex < spaceIndex {
+			return nil, fmt.Errorf("invalid tree entry: missing name")
+		}
+		if nullIndex+21 > len(content) {
+			return nil, fmt.Errorf("invalid tree entry: truncated SHA")
+		}
+
+		entries = append(entries, TreeEntry{
+			Mode: string(content[:spaceIndex]),
+			Name: string(content[spaceIndex+1 : nullIndex]),
+			SHA:  content[nullIndex+1 : nullIndex+21],
+		})
+		content = content[nullIndex+21:]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+)
+
+type UpdateIndexCommand struct{}
+
+func (c *UpdateIndexCommand) GetName() string {
+	return "update-index"
+}
+
+func (c *UpdateIndexCommand) Execute(cmd *Command) error {
+	// Format: update-index --unresolve [--] <path>...
+	unresolve := false
+	var paths []string
+
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			paths = append(paths, cmd.Args[i+1:]...)
+			break
+		}
+		switch {
+		case arg == "--unresolve":
+			unresolve = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown option: %s", arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if !unresolve || len(paths) == 0 {
+		return fmt.Errorf("usage: update-index --unresolve [--] <path>...")
+	}
+
+	index, err := ReadIndex(indexPath)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range paths {
+		path, err := worktreePath(arg)
+		if err != nil {
+			return err
+		}
+		if !index.Unresolve(path) {
+			return fmt.Errorf("%s: no resolve-undo information", arg)
+		}
+	}
+
+	return index.Write(indexPath)
+}
@@ -0,0 +1,236 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type UpdateRefCommand struct{}
+
+func (c *UpdateRefCommand) GetName() string {
+	return "update-ref"
+}
+
+func (c *UpdateRefCommand) Execute(cmd *Command) error {
+	// Format: update-ref [-m <reason>] [--no-deref] <ref> <newvalue> [<oldvalue>]
+	//         update-ref [-m <reason>] [--no-deref] -d <ref> [<oldvalue>]
+	//         update-ref [-m <reason>] [--no-deref] --stdin [-z]
+	var noDeref, remove, stdin, nulTerminated bool
+	var positional []string
+
+	for i := 0; i < len(cmd.Args); i++ {
+		switch arg := cmd.Args[i]; arg {
+		case "--no-deref":
+			noDeref = true
+		case "-d":
+			remove = true
+		case "--stdin":
+			stdin = true
+		case "-z":
+			nulTerminated = true
+		case "-m":
+			// There are no reflogs to record the reason in
+			if i+1 == len(cmd.Args) {
+				return fmt.Errorf("option '-m' requires a value")
+			}
+			i++
+		case "--create-reflog":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if stdin {
+		if remove || len(positional) > 0 {
+			return fmt.Errorf("--stdin takes no other arguments")
+		}
+		return updateRefsFromStdin(os.Stdin, nulTerminated, noDeref)
+	}
+	if nulTerminated {
+		return fmt.Errorf("-z only makes sense with --stdin")
+	}
+
+	tx := refStore.Begin()
+	if remove {
+		if len(positional) < 1 || len(positional) > 2 {
+			return fmt.Errorf("usage: update-ref -d <ref> [<oldvalue>]")
+		}
+		oldSHA := ""
+		if len(positional) == 2 {
+			var err error
+			if oldSHA, err = refValue(positional[1]); err != nil {
+				return fmt.Errorf("%s: not a valid old SHA1", positional[1])
+			}
+		}
+		tx.Delete(positional[0], oldSHA, noDeref)
+		return tx.Commit()
+	}
+
+	if len(positional) < 2 || len(positional) > 3 {
+		return fmt.Errorf("usage: update-ref <ref> <newvalue> [<oldvalue>]")
+	}
+	newSHA, err := refValue(positional[1])
+	if err != nil {
+		return fmt.Errorf("%s: not a valid SHA1", positional[1])
+	}
+	oldSHA := ""
+	if len(positional) == 3 {
+		if oldSHA, err = refValue(positional[2]); err != nil {
+			return fmt.Errorf("%s: not a valid old SHA1", positional[2])
+		}
+	}
+	tx.Update(positional[0], newSHA, oldSHA, noDeref)
+	return tx.Commit()
+}
+
+// refValue resolves a new or old value of a ref update. The empty
+// string and forty zeros both mean "no object"
+func refValue(value string) (string, error) {
+	if value == "" || value == zeroSHA {
+		return zeroSHA, nil
+	}
+	return ResolveRevision(value)
+}
+
+// updateRefArity is the least and most fields (the ref, then values)
+// each --stdin command takes
+var updateRefArity = map[string][2]int{
+	"update": {2, 3},
+	"create": {2, 2},
+	"delete": {1, 2},
+	"verify": {1, 2},
+}
+
+// updateRefsFromStdin reads commands and applies them as one transaction:
+//
+//	update SP <ref> SP <newvalue> [SP <oldvalue>] LF
+//	create SP <ref> SP <newvalue> LF
+//	delete SP <ref> [SP <oldvalue>] LF
+//	verify SP <ref> [SP <oldvalue>] LF
+//	option SP no-deref LF
+//
+// With -z, the ref and each value are NUL terminated instead, and an
+// empty value stands for one that is left out
+func updateRefsFromStdin(in io.Reader, nulTerminated, noDeref bool) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	if nulTerminated {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return 0, nil, fmt.Errorf("unterminated -z input")
+			}
+			return 0, nil, nil
+		})
+	}
+
+	nextField := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		return scanner.Text(), true
+	}
+
+	tx := refStore.Begin()
+	nextNoDeref := false
+	for {
+		line, ok := nextField()
+		if !ok {
+			break
+		}
+		if !nulTerminated && line == "" {
+			continue
+		}
+
+		command, rest, _ := strings.Cut(line, " ")
+		var fields []string
+		if nulTerminated {
+			fields = []string{rest}
+			for i := 1; i < updateRefArity[command][1]; i++ {
+				value, ok := nextField()
+				if !ok {
+					return fmt.Errorf("%s %s: missing value", command, rest)
+				}
+				fields = append(fields, value)
+			}
+			// An empty value is a missing one
+			for len(fields) > 1 && fields[len(fields)-1] == "" {
+				fields = fields[:len(fields)-1]
+			}
+		} else {
+			fields = strings.Fields(rest)
+		}
+
+		if command == "option" {
+			if len(fields) != 1 || fields[0] != "no-deref" {
+				return fmt.Errorf("option unknown: %s", rest)
+			}
+			nextNoDeref = true
+			continue
+		}
+
+		arity, known := updateRefArity[command]
+		if !known {
+			return fmt.Errorf("unknown command: %s", line)
+		}
+		if len(fields) < arity[0] || len(fields) > arity[1] || fields[0] == "" {
+			return fmt.Errorf("%s: wrong number of arguments: %s", command, line)
+		}
+
+		name := fields[0]
+		values := make([]string, len(fields)-1)
+		for i, value := range fields[1:] {
+			sha, err := refValue(value)
+			if err != nil {
+				return fmt.Errorf("%s %s: invalid value: %s", command, name, value)
+			}
+			values[i] = sha
+		}
+		skipDeref := noDeref || nextNoDeref
+		nextNoDeref = false
+
+		switch command {
+		case "update":
+			oldSHA := ""
+			if len(values) == 2 {
+				oldSHA = values[1]
+			}
+			tx.Update(name, values[0], oldSHA, skipDeref)
+		case "create":
+			if values[0] == zeroSHA {
+				return fmt.Errorf("create %s: zero new value", name)
+			}
+			tx.Create(name, values[0], skipDeref)
+		case "delete":
+			oldSHA := ""
+			if len(values) == 1 {
+				if values[0] == zeroSHA {
+					return fmt.Errorf("delete %s: zero old value", name)
+				}
+				oldSHA = values[0]
+			}
+			tx.Delete(name, oldSHA, skipDeref)
+		case "verify":
+			// A missing old value means the ref must not exist
+			oldSHA := zeroSHA
+			if len(values) == 1 {
+				oldSHA = values[0]
+			}
+			tx.Verify(name, oldSHA, skipDeref)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stdin: %w", err)
+	}
+
+	return tx.Commit()
+}
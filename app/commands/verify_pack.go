@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type VerifyPackCommand struct{}
+
+func (c *VerifyPackCommand) GetName() string {
+	return "verify-pack"
+}
+
+func (c *VerifyPackCommand) Execute(cmd *Command) error {
+	// Format: verify-pack [-v | -s] <pack>.idx...
+	var verbose, statOnly bool
+	var paths []string
+
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		case "-s", "--stat-only":
+			statOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown option: %s", arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: verify-pack [-v | -s] <pack>.idx...")
+	}
+
+	failed := false
+	for _, path := range paths {
+		if err := verifyPack(path, verbose, statOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("pack verification failed")
+	}
+	return nil
+}
+
+// packObjectInfo is one line of verify-pack -v output
+type packObjectInfo struct {
+	sha        string
+	objType    GitObjectType
+	size       int
+	sizeInPack uint64
+	offset     uint64
+	depth      int
+	baseSHA    string
+}
+
+// verifyPack checks a pack against its index: the pack checksum, the CRC32
+// of every entry and the SHA-1 of every object after delta resolution
+func verifyPack(path string, verbose, statOnly bool) error {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".idx"), ".pack")
+	packPath := base + ".pack"
+
+	pack, err := OpenPackfile(packPath, objectDB)
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+
+	info, err := pack.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading pack size: %w", err)
+	}
+	packSize := uint64(info.Size())
+	if packSize < 12+20 {
+		return fmt.Errorf("%s: pack too short", packPath)
+	}
+
+	// The trailer must match both the pack content and the index
+	sum := sha1.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(pack.file, 0, int64(packSize-20))); err != nil {
+		return fmt.Errorf("error hashing pack: %w", err)
+	}
+	trailer := make([]byte, 20)
+	if _, err := pack.file.ReadAt(trailer, int64(packSize-20)); err != nil {
+		return fmt.Errorf("error reading pack trailer: %w", err)
+	}
+	if !bytes.Equal(sum.Sum(nil), trailer) {
+		return fmt.Errorf("%s: pack checksum mismatch", packPath)
+	}
+	if !bytes.Equal(trailer, pack.Index.PackChecksum[:]) {
+		return fmt.Errorf("%s: pack checksum does not match its index", packPath)
+	}
+
+	// Entries in pack order, so each one's size in the pack is the gap to the next
+	entries := make([]PackIndexEntry, len(pack.Index.Entries))
+	copy(entries, pack.Index.Entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	shaAt := make(map[uint64]string, len(entries))
+	for _, entry := range entries {
+		shaAt[entry.Offset] = hex.EncodeToString(entry.SHA[:])
+	}
+
+	objects := make([]packObjectInfo, len(entries))
+	depthAt := make(map[uint64]int, len(entries))
+	for i, entry := range entries {
+		end := packSize - 20
+		if i+1 < len(entries) {
+			end = entries[i+1].Offset
+		}
+
+		raw := make([]byte, end-entry.Offset)
+		if _, err := pack.file.ReadAt(raw, int64(entry.Offset)); err != nil {
+			return fmt.Errorf("error reading entry at offset %d: %w", entry.Offset, err)
+		}
+		if crc32.ChecksumIEEE(raw) != entry.CRC32 {
+			return fmt.Errorf("%s: CRC32 mismatch for object %x at offset %d", packPath, entry.SHA, entry.Offset)
+		}
+
+		object := packObjectInfo{
+			sha:        shaAt[entry.Offset],
+			sizeInPack: end - entry.Offset,
+			offset:     entry.Offset,
+		}
+
+		packEntry, err := pack.readEntry(entry.Offset)
+		if err != nil {
+			return err
+		}
+		object.size = packEntry.size
+
+		// Bases come before their deltas for OFS_DELTA, but a REF_DELTA
+		// base can be anywhere, so depths are worked out recursively
+		object.depth, object.baseSHA, err = packEntryDepth(pack, packEntry, shaAt, depthAt)
+		if err != nil {
+			return err
+		}
+		depthAt[entry.Offset] = object.depth
+
+		// Reading the object rebuilds it and checks it against the index
+		object.objType, _, err = pack.Read(entry.SHA)
+		if err != nil {
+			return err
+		}
+
+		objects[i] = object
+	}
+
+	if verbose || statOnly {
+		printPackInfo(objects, verbose)
+	}
+	if verbose {
+		fmt.Printf("%s: ok\n", packPath)
+	}
+	return nil
+}
+
+// packEntryDepth returns the delta chain length of an entry and the name
+// of its immediate base. Non-delta entries have depth 0
+func packEntryDepth(pack *Packfile, entry *packEntry, shaAt map[uint64]string, depthAt map[uint64]int) (int, string, error) {
+	var baseOffset uint64
+	var baseSHA string
+
+	switch entry.objType {
+	case OBJ_OFS_DELTA:
+		baseOffset = entry.baseOffset
+		baseSHA = shaAt[baseOffset]
+	case OBJ_REF_DELTA:
+		baseSHA = entry.baseSHA
+		var raw [20]byte
+		hex.Decode(raw[:], []byte(baseSHA))
+		i := pack.Index.Find(raw)
+		if i < 0 {
+			return 0, "", fmt.Errorf("%s: REF_DELTA base %s is not in the pack", pack.Path, baseSHA)
+		}
+		baseOffset = pack.Index.Entries[i].Offset
+	default:
+		return 0, "", nil
+	}
+
+	if depth, exists := depthAt[baseOffset]; exists {
+		return depth + 1, baseSHA, nil
+	}
+
+	// Guard against delta cycles while walking an unseen base
+	depthAt[baseOffset] = maxDeltaDepth
+	baseEntry, err := pack.readEntry(baseOffset)
+	if err != nil {
+		return 0, "", err
+	}
+	depth, _, err := packEntryDepth(pack, baseEntry, shaAt, depthAt)
+	if err != nil {
+		return 0, "", err
+	}
+	if depth >= maxDeltaDepth {
+		return 0, "", fmt.Errorf("%s: delta chain too deep at offset %d", pack.Path, baseOffset)
+	}
+	depthAt[baseOffset] = depth
+	return depth + 1, baseSHA, nil
+}
+
+// printPackInfo prints the verify-pack -v object list and chain histogram
+func printPackInfo(objects []packObjectInfo, verbose bool) {
+	nonDelta := 0
+	var histogram []int
+
+	for _, object := range objects {
+		if verbose {
+			fmt.Printf("%s %-6s %d %d %d", object.sha, object.objType, object.size, object.sizeInPack, object.offset)
+			if object.depth > 0 {
+				fmt.Printf(" %d %s", object.depth, object.baseSHA)
+			}
+			fmt.Println()
+		}
+
+		if object.depth == 0 {
+			nonDelta++
+			continue
+		}
+		for len(histogram) < object.depth {
+			histogram = append(histogram, 0)
+		}
+		histogram[object.depth-1]++
+	}
+
+	if nonDelta > 0 {
+		fmt.Printf("non delta: %d %s\n", nonDelta, pluralObjects(nonDelta))
+	}
+	for i, count := range histogram {
+		if count > 0 {
+			fmt.Printf("chain length = %d: %d %s\n", i+1, count, pluralObjects(count))
+		}
+	}
+}
+
+func pluralObjects(n int) string {
+	if n == 1 {
+		return "object"
+	}
+	return "objects"
+}
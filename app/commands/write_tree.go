@@ -1,104 +1,103 @@
 package commands
 
 import (
-	"bytes"
 	"encoding/hex"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
+	"strconv"
+	"strings"
 )
 
 type WriteTreeCommand struct{}
 
-type TreeEntry struct {
-	Mode string
-	Name string
-	SHA  []byte
-}
-
 func (c *WriteTreeCommand) GetName() string {
 	return "write-tree"
 }
 
 func (c *WriteTreeCommand) Execute(cmd *Command) error {
-	workDir, err := os.Getwd()
+	// Format: write-tree [--missing-ok]
+	missingOK := false
+	for _, arg := range cmd.Args {
+		switch arg {
+		case "--missing-ok":
+			missingOK = true
+		default:
+			return fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	index, err := ReadIndex(indexPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting working directory: %s\n", err)
 		return err
 	}
 
-	sha := CreateTree(workDir)
-	fmt.Print(sha)
+	for _, entry := range index.Entries {
+		if entry.Stage() != 0 {
+			return fmt.Errorf("%s: unmerged (%x)", entry.Path, entry.SHA)
+		}
+		if !missingOK && entry.Mode != 0160000 && !objectDB.Has(hex.EncodeToString(entry.SHA[:])) {
+			return fmt.Errorf("invalid object %06o %x for '%s'", entry.Mode, entry.SHA, entry.Path)
+		}
+	}
+
+	index.Tree = writeIndexTree(index.Entries, "", index.Tree)
+	if err := index.Write(indexPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("%x", index.Tree.SHA)
 	return nil
 }
 
-func CreateTree(dirPath string) string {
-	var entries []TreeEntry
-
-	// Read directory entries
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading directory: %s\n", err)
-		os.Exit(1)
+// writeIndexTree writes the tree for the index entries below prefix, and
+// the trees of its subdirectories, returning the cache tree that describes
+// them. Directories the cache tree still has a valid SHA for are not
+// rewritten. Entries are sorted by path, so everything below a directory
+// is contiguous
+func writeIndexTree(entries []*IndexEntry, prefix string, cached *CacheTree) *CacheTree {
+	if cached.Valid() && cached.EntryCount == len(entries) && objectDB.Has(hex.EncodeToString(cached.SHA[:])) {
+		return cached
 	}
 
-	for _, file := range files {
-		// Skip .git directory
-		if file.Name() == ".git" {
-			continue
-		}
+	node := &CacheTree{EntryCount: len(entries)}
+	if cached != nil {
+		node.Name = cached.Name
+	}
 
-		fullPath := filepath.Join(dirPath, file.Name())
-		info, err := file.Info()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting file info: %s\n", err)
+	tree := &Tree{}
+	for i := 0; i < len(entries); {
+		name := strings.TrimPrefix(entries[i].Path, prefix)
+
+		dir, _, isDir := strings.Cut(name, "/")
+		if !isDir {
+			tree.Entries = append(tree.Entries, TreeEntry{
+				Mode: fmt.Sprintf("%o", entries[i].Mode),
+				Name: name,
+				SHA:  entries[i].SHA[:],
+			})
+			i++
 			continue
 		}
 
-		var sha []byte
-		var mode string
-
-		if file.IsDir() {
-			// Recursively create tree for subdirectory
-			subTreeSHA := CreateTree(fullPath)
-			sha, _ = hex.DecodeString(subTreeSHA)
-			mode = "40000" // directory mode
-		} else {
-			// Create blob for file
-			sha = CreateBlob(fullPath, false)
-			mode = GetMode(info)
+		dirPrefix := prefix + dir + "/"
+		end := i
+		for end < len(entries) && strings.HasPrefix(entries[end].Path, dirPrefix) {
+			end++
 		}
-
-		entries = append(entries, TreeEntry{
-			Mode: mode,
-			Name: file.Name(),
-			SHA:  sha,
-		})
+		subtree := writeIndexTree(entries[i:end], dirPrefix, cached.subtree(dir))
+		subtree.Name = dir
+		node.Subtrees = append(node.Subtrees, subtree)
+		tree.Entries = append(tree.Entries, TreeEntry{Mode: "40000", Name: dir, SHA: subtree.SHA[:]})
+		i = end
 	}
+	node.sortSubtrees()
 
-	// Sort entries alphabetically by name
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Name < entries[j].Name
-	})
-
-	// Build tree content
-	var treeContent bytes.Buffer
-	for _, entry := range entries {
-		// Format: <mode> <name>\0<20_byte_sha>
-		fmt.Fprintf(&treeContent, "%s %s\x00", entry.Mode, entry.Name)
-		treeContent.Write(entry.SHA)
-	}
-
-	// Write tree object using common function
-	sha := WriteGitObject(TreeObject, treeContent.Bytes(), true)
-	return string(sha)
+	hex.Decode(node.SHA[:], []byte(tree.Write()))
+	return node
 }
 
+// GetMode returns the tree entry mode for a worktree entry: 100644,
+// 100755, 120000 for a symlink or 160000 for a nested repository
 func GetMode(info os.FileInfo) string {
-	// Check if file is executable
-	if info.Mode()&0111 != 0 {
-		return "100755" // executable file
-	}
-	return "100644" // regular file
+	return strconv.FormatUint(uint64(indexMode(info)), 8)
 }
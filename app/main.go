@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -19,24 +20,20 @@ func main() {
 
 	switch command := os.Args[1]; command {
 	case "init":
-		// TODO: Uncomment the code below to pass the first stage!
-
-		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
-			}
-		}
-
-		headFileContents := []byte("ref: refs/heads/main\n")
-		if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
+		initCommand := commands.InitCommand{}
+		if err := initCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
 		}
 
-		fmt.Println("Initialized git directory")
-
 	case "cat-file":
 		catFileCommand := commands.CatFileCommand{}
-		catFileCommand.Execute(&commands.Command{Args: os.Args[2:]})
+		if err := catFileCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			if !errors.Is(err, commands.ErrExitStatus) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
 
 	case "hash-object":
 		hashObjectCommand := commands.HashObjectCommand{}
@@ -44,19 +41,165 @@ func main() {
 
 	case "ls-tree":
 		lsTreeCommand := commands.LsTreeComand{}
-		lsTreeCommand.Execute(&commands.Command{Args: os.Args[2:]})
+		if err := lsTreeCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
 
 	case "write-tree":
 		writeTreeCommand := commands.WriteTreeCommand{}
-		writeTreeCommand.Execute(&commands.Command{Args: os.Args[1:]})
+		if err := writeTreeCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "add":
+		addCommand := commands.AddCommand{}
+		if err := addCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "rm":
+		rmCommand := commands.RmCommand{}
+		if err := rmCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "ls-files":
+		lsFilesCommand := commands.LsFilesCommand{}
+		if err := lsFilesCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "update-index":
+		updateIndexCommand := commands.UpdateIndexCommand{}
+		if err := updateIndexCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "status":
+		statusCommand := commands.StatusCommand{}
+		if err := statusCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "check-ignore":
+		checkIgnoreCommand := commands.CheckIgnoreCommand{}
+		if err := checkIgnoreCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			if !errors.Is(err, commands.ErrExitStatus) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
+
+	case "config":
+		configCommand := commands.ConfigCommand{}
+		if err := configCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			if !errors.Is(err, commands.ErrExitStatus) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
+
+	case "show-ref":
+		showRefCommand := commands.ShowRefCommand{}
+		if err := showRefCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			if !errors.Is(err, commands.ErrExitStatus) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
+
+	case "symbolic-ref":
+		symbolicRefCommand := commands.SymbolicRefCommand{}
+		if err := symbolicRefCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			if !errors.Is(err, commands.ErrExitStatus) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
+
+	case "update-ref":
+		updateRefCommand := commands.UpdateRefCommand{}
+		if err := updateRefCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "for-each-ref":
+		forEachRefCommand := commands.ForEachRefCommand{}
+		if err := forEachRefCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
 
 	case "commit-tree":
 		commitTreeCommand := commands.CommitTreeCommand{}
-		commitTreeCommand.Execute(&commands.Command{Args: os.Args[2:]})
+		if err := commitTreeCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "tag":
+		tagCommand := commands.TagCommand{}
+		if err := tagCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "index-pack":
+		indexPackCommand := commands.IndexPackCommand{}
+		if err := indexPackCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "verify-pack":
+		verifyPackCommand := commands.VerifyPackCommand{}
+		if err := verifyPackCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "pack-objects":
+		packObjectsCommand := commands.PackObjectsCommand{}
+		if err := packObjectsCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "repack":
+		repackCommand := commands.RepackCommand{}
+		if err := repackCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "gc":
+		gcCommand := commands.GcCommand{}
+		if err := gcCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "fsck":
+		fsckCommand := commands.FsckCommand{}
+		if err := fsckCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
 
 	case "clone":
 		cloneCommand := commands.CloneCommand{}
-		cloneCommand.Execute(&commands.Command{Args: os.Args[2:]})
+		if err := cloneCommand.Execute(&commands.Command{Args: os.Args[2:]}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error cloning repository: %s\n", err)
+			os.Exit(1)
+		}
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)